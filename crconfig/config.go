@@ -1,113 +1,225 @@
 package crconfig
 
 import (
-	"bufio"
 	"os"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
+// Layers a value can come from, as given by Dump
+const (
+	LayerCLI     = "cli"
+	LayerEnv     = "env"
+	LayerRemote  = "remote"
+	LayerFile    = "file"
+	LayerDefault = "default"
+)
+
 var conf map[string]string
 var cli map[string]string
 
-// Read parses file for valid config, if you have one
+var (
+	reloadMutex sync.RWMutex
+	reloadFuncs []func()
+)
+
+// Read parses file for valid config, if you have one.
+// file can also be a directory with profile files, see Profile.
 func Read(file string) error {
-	f, err := os.Open(file)
+	values, sws, prof, profiled, err := readConfig(file, selectProfile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	conf = map[string]string{"-d": "DEBUG"}
-	cli = map[string]string{}
-	args := map[string]string{}
-
-	scan := bufio.NewScanner(f)
-	for scan.Scan() {
-		line := scan.Text()
-		if line == "" || line[0] == '#' {
-			continue
-		}
-		if line[0] == '-' {
-			parts := strings.SplitN(line, " ", 2)
-			args[parts[0]] = parts[1]
-		} else {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) > 1 {
-				key := strings.TrimSpace(parts[0])
-				val := strings.TrimSpace(parts[1])
-				conf[key] = val
-			}
-		}
+	conf = values
+	profile = prof
+	fileSwitches = sws
+	if profiled {
+		fileSwitches = append([]Switch{profileSwitch()}, sws...)
 	}
 
-	parseSwitches(args)
+	cli, positional, _, _ = parseArgs(os.Args, allSwitches(), false)
 
-	// find variables
-	for k, v := range conf {
-		ref := Get(v, v)
-		if _, is := conf[v]; is {
-			for is {
-				ref = Get(ref, ref)
-				_, is = conf[ref]
+	// check variables for cycles and syntax errors
+	for key, val := range conf {
+		if _, err := interpolate(val, []string{key}); err != nil {
+			if _, is := err.(variableError); is {
+				return err
 			}
-			conf[k] = ref
 		}
 	}
 
+	reloaded()
 	return nil
 }
 
+// OnReload registers fn to be called whenever the config was read or changed remotely
+func OnReload(fn func()) {
+	reloadMutex.Lock()
+	reloadFuncs = append(reloadFuncs, fn)
+	reloadMutex.Unlock()
+}
+
+func reloaded() {
+	reloadMutex.RLock()
+	fns := append([]func(){}, reloadFuncs...)
+	reloadMutex.RUnlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// ParseFile parses file into a map for the given profile, without using it as config.
+// With an empty profile only the common values are used.
+// Variables and secret references are kept as they are.
+func ParseFile(file, profile string) (map[string]string, error) {
+	values, _, _, _, err := readConfig(file, func(map[string]string) string {
+		return profile
+	})
+	return values, err
+}
+
 // Get gets the value according to the given key.
 // if key is not found, def is returned
 func Get(key, def string) string {
-	if val, ok := cli[key]; ok {
+	if val, ok, err := Lookup(key); ok && err == nil {
 		return val
 	}
+	return def
+}
+
+// Lookup gets the value according to the given key, with variables and secret references resolved.
+// found tells if the key exists at all, err if resolving its value failed.
+func Lookup(key string) (val string, found bool, err error) {
+	if _, found = rawLookup(key); !found {
+		return "", false, nil
+	}
+	val, err = lookupPath(key, []string{key})
+	return val, true, err
+}
+
+// rawLookup finds the value of key, where cli wins over environment, environment over remote and remote over config file
+func rawLookup(key string) (string, bool) {
+	val, layer := find(key)
+	return val, layer != ""
+}
+
+// find finds the unresolved value of key and the layer it comes from.
+// layer is empty, if key is not found.
+func find(key string) (val, layer string) {
+	if val, ok := cli[key]; ok {
+		return val, LayerCLI
+	}
 	if val := os.Getenv(key); val != "" {
-		return val
+		return val, LayerEnv
+	}
+	if r := currentRemote(); r != nil {
+		if val, ok := r.Get(key); ok {
+			return val, LayerRemote
+		}
 	}
 	if val, ok := conf[key]; ok {
-		return val
+		return val, LayerFile
 	}
-	return def
+	return "", ""
 }
 
 // GetBool gets the value as bool, according to the given key.
-// if key is not found, def is used
+// true, 1, yes and on are true, false, 0, no and off are false.
+// if key is not found or can not be parsed, def is used
 func GetBool(key string, def bool) bool {
-	if val := Get(key, ""); val != "" {
-		return strings.ToLower(val) == "true"
+	if b, ok, err := LookupBool(key); ok && err == nil {
+		return b
 	}
 	return def
 }
 
+// LookupBool gets the value as bool, see GetBool.
+// found tells if the key exists at all, err if its value could not be parsed.
+func LookupBool(key string) (bool, bool, error) {
+	return LookupAs[bool](key)
+}
+
 // GetInt gets the value as int64, according to the given key.
-// if key is not found, def is used
+// if key is not found or can not be parsed, def is used
 func GetInt(key string, def int64) int64 {
-	if val := Get(key, ""); val != "" {
-		n, _ := strconv.ParseInt(val, 10, 64)
+	if n, ok, err := LookupInt(key); ok && err == nil {
 		return n
 	}
 	return def
 }
 
+// LookupInt gets the value as int64.
+// found tells if the key exists at all, err if its value could not be parsed.
+func LookupInt(key string) (int64, bool, error) {
+	return LookupAs[int64](key)
+}
+
 // GetFloat gets the value as float64, according to the given key.
-// if key is not found, def is used
+// if key is not found or can not be parsed, def is used
 func GetFloat(key string, def float64) float64 {
-	if val := Get(key, ""); val != "" {
-		n, _ := strconv.ParseFloat(val, 64)
+	if n, ok, err := LookupFloat(key); ok && err == nil {
 		return n
 	}
 	return def
 }
 
-// GetDuration gets the value as int64, according to the given key.
-// if key is not found, def is used
+// LookupFloat gets the value as float64.
+// found tells if the key exists at all, err if its value could not be parsed.
+func LookupFloat(key string) (float64, bool, error) {
+	return LookupAs[float64](key)
+}
+
+// GetDuration gets the value as time.Duration, according to the given key.
+// The value is a Go duration string like "1500ms" or "2m", bare integers are nanoseconds.
+// if key is not found, can not be parsed or is 0, def is used, use LookupDuration to read a 0
 func GetDuration(key string, def time.Duration) time.Duration {
-	if val := GetInt(key, 0); val != 0 {
-		return time.Duration(val)
+	return GetDurationIn(key, time.Nanosecond, def)
+}
+
+// GetDurationIn works like GetDuration, but bare integers are taken as multiples of unit.
+// E.g. GetDurationIn("TIMEOUT", time.Millisecond, 0) reads "1500" as well as "1.5s".
+func GetDurationIn(key string, unit, def time.Duration) time.Duration {
+	if d, ok, err := LookupDuration(key, unit); ok && err == nil && d != 0 {
+		return d
 	}
 	return def
 }
+
+// LookupDuration gets the value as time.Duration, where bare integers are multiples of unit.
+// found tells if the key exists at all, err if its value could not be parsed.
+func LookupDuration(key string, unit time.Duration) (d time.Duration, found bool, err error) {
+	val, found, err := Lookup(key)
+	if !found || err != nil {
+		return 0, found, err
+	}
+	d, err = ParseDuration(val, unit)
+	if err != nil {
+		err = redactErr(key, val, err)
+	}
+	return d, true, err
+}
+
+// GetBytes gets the value as number of bytes, according to the given key.
+// The value may have a unit like "10MB" or "512KiB", see ParseBytes.
+// if key is not found or can not be parsed, def is used
+func GetBytes(key string, def int64) int64 {
+	if n, ok, err := LookupBytes(key); ok && err == nil {
+		return n
+	}
+	return def
+}
+
+// LookupBytes gets the value as number of bytes.
+// found tells if the key exists at all, err if its value could not be parsed.
+func LookupBytes(key string) (n int64, found bool, err error) {
+	val, found, err := Lookup(key)
+	if !found || err != nil {
+		return 0, found, err
+	}
+	n, err = ParseBytes(val)
+	if err != nil {
+		err = redactErr(key, val, err)
+	}
+	return n, true, err
+}
@@ -1,8 +1,15 @@
 package crconfig_test
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"cleverreach.com/crtools/crconfig"
 	"github.com/stretchr/testify/assert"
@@ -33,6 +40,173 @@ func TestGets(t *testing.T) {
 	test.Equal(true, crconfig.GetBool("WORKS_GREAT", false))
 }
 
+func TestUnits(t *testing.T) {
+	test := assert.New(t)
+
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	test.Equal(5*time.Second, crconfig.GetDuration("TIMEOUT", 0))
+	test.Equal(time.Duration(1500), crconfig.GetDuration("REQUEST_TIMEOUT", 0))
+	test.Equal(1500*time.Millisecond, crconfig.GetDurationIn("REQUEST_TIMEOUT", time.Millisecond, 0))
+	test.Equal(5*time.Second, crconfig.GetDurationIn("TIMEOUT", time.Millisecond, 0))
+	test.Equal(time.Minute, crconfig.GetDuration("NOT_THERE", time.Minute))
+	test.Equal(time.Minute, crconfig.GetDuration("FIRST_VAL", time.Minute))
+
+	os.Setenv("ZERO_TIMEOUT", "0")
+	defer os.Unsetenv("ZERO_TIMEOUT")
+	test.Equal(time.Minute, crconfig.GetDuration("ZERO_TIMEOUT", time.Minute))
+	d, found, err := crconfig.LookupDuration("ZERO_TIMEOUT", time.Second)
+	test.True(found)
+	test.Nil(err)
+	test.Equal(time.Duration(0), d)
+
+	_, found, err = crconfig.LookupDuration("FIRST_VAL", time.Second)
+	test.True(found)
+	test.NotNil(err)
+
+	test.EqualValues(10000000, crconfig.GetBytes("UPLOAD_LIMIT", 0))
+	test.EqualValues(512*1024, crconfig.GetBytes("BUFFER_SIZE", 0))
+	test.EqualValues(42, crconfig.GetBytes("MY_NUMVBER", 0))
+	test.EqualValues(7, crconfig.GetBytes("BAD_SIZE", 7))
+
+	_, found, err = crconfig.LookupBytes("BAD_SIZE")
+	test.True(found)
+	test.NotNil(err)
+
+	n, err := crconfig.ParseBytes("1.5GiB")
+	test.Nil(err)
+	test.EqualValues(3<<29, n)
+}
+
+func TestTyped(t *testing.T) {
+	test := assert.New(t)
+
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	n, found, err := crconfig.LookupInt("FIRST_VAL")
+	test.True(found)
+	test.NotNil(err)
+	test.EqualValues(0, n)
+	test.EqualValues(7, crconfig.GetInt("FIRST_VAL", 7))
+
+	n, found, err = crconfig.LookupInt("NOT_THERE")
+	test.False(found)
+	test.Nil(err)
+
+	f, found, err := crconfig.LookupFloat("TRY_FLOAT")
+	test.True(found)
+	test.Nil(err)
+	test.Equal(3.45, f)
+
+	b, found, err := crconfig.LookupBool("ENABLED")
+	test.True(b && found && err == nil)
+	test.False(crconfig.GetBool("DISABLED", true))
+	test.True(crconfig.GetBool("FIRST_VAL", true))
+
+	test.Equal(5*time.Second, crconfig.As("TIMEOUT", time.Duration(0)))
+	test.Equal(uint8(42), crconfig.As[uint8]("MY_NUMVBER", 0))
+	test.Equal(uint8(1), crconfig.As[uint8]("NEGATIVE", 1))
+	test.Equal(int16(-12), crconfig.As[int16]("NEGATIVE", 1))
+	test.Equal(float32(3.45), crconfig.As[float32]("TRY_FLOAT", 0))
+	test.True(crconfig.As("ENABLED", false))
+	test.Equal("10.0.0.1", crconfig.As[net.IP]("SERVER_IP", nil).String())
+	test.Equal(time.Date(2020, 11, 8, 12, 0, 0, 0, time.UTC), crconfig.As("STARTED", time.Time{}))
+
+	u := crconfig.As[*url.URL]("ENDPOINT", nil)
+	if test.NotNil(u) {
+		test.Equal("api.example.com", u.Host)
+	}
+	test.Equal("/v1", crconfig.As("ENDPOINT", url.URL{}).Path)
+
+	_, found, err = crconfig.LookupAs[chan int]("FIRST_VAL")
+	test.True(found)
+	test.NotNil(err)
+}
+
+func TestSecrets(t *testing.T) {
+	test := assert.New(t)
+
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	f, err := ioutil.TempFile("", "crconfig")
+	test.Nil(err)
+	defer os.Remove(f.Name())
+	f.WriteString("file secret\n")
+	f.Close()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := crconfig.SealVault([]byte("DB_PASS = vault secret\nODD_PASS = \"pa #ss ${X}\"\n"), key)
+	test.Nil(err)
+	vf, err := ioutil.TempFile("", "crconfig")
+	test.Nil(err)
+	defer os.Remove(vf.Name())
+	vf.Write(sealed)
+	vf.Close()
+
+	os.Setenv("TEST_VAULT_KEY", base64.StdEncoding.EncodeToString(key))
+	v, err := crconfig.OpenVault(vf.Name(), "TEST_VAULT_KEY")
+	test.Nil(err)
+	crconfig.RegisterProvider("vault", v)
+
+	os.Setenv("TEST_SECRET_FILE", "secret:file:"+f.Name())
+	os.Setenv("TEST_SECRET_SOURCE", "env secret")
+	os.Setenv("TEST_SECRET_ENV", "secret:env:TEST_SECRET_SOURCE")
+	os.Setenv("TEST_SECRET_VAULT", "secret:vault:DB_PASS")
+	os.Setenv("TEST_SECRET_MISSING", "secret:vault:NOT_THERE")
+	os.Setenv("TEST_SECRET_NUM", "no number")
+	defer func() {
+		for _, k := range []string{"TEST_VAULT_KEY", "TEST_SECRET_FILE", "TEST_SECRET_SOURCE", "TEST_SECRET_ENV", "TEST_SECRET_VAULT", "TEST_SECRET_MISSING", "TEST_SECRET_NUM"} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	test.Equal("file secret", crconfig.Get("TEST_SECRET_FILE", ""))
+	test.Equal("env secret", crconfig.Get("TEST_SECRET_ENV", ""))
+	test.Equal("vault secret", crconfig.Get("TEST_SECRET_VAULT", ""))
+	odd, err := v.Secret("ODD_PASS")
+	test.Nil(err)
+	test.Equal(`"pa #ss ${X}"`, odd)
+	test.Equal("default", crconfig.Get("TEST_SECRET_MISSING", "default"))
+
+	_, found, err := crconfig.Lookup("TEST_SECRET_MISSING")
+	test.True(found)
+	test.NotNil(err)
+
+	test.True(crconfig.IsSecret("TEST_SECRET_VAULT"))
+
+	// plain values with a provider's prefix keep their meaning
+	os.Setenv("TEST_PLAIN_FILE", "file:report.csv")
+	defer os.Unsetenv("TEST_PLAIN_FILE")
+	test.Equal("file:report.csv", crconfig.Get("TEST_PLAIN_FILE", ""))
+	test.False(crconfig.IsSecret("TEST_PLAIN_FILE"))
+	test.False(crconfig.IsSecret("TEST_SECRET_SOURCE"))
+	test.Equal(crconfig.Redacted, crconfig.Redact("TEST_SECRET_FILE", "file secret"))
+
+	d := struct {
+		Num int `env:"TEST_SECRET_NUM" secret:"true"`
+	}{}
+	err = crconfig.Bind(&d)
+	if test.NotNil(err) {
+		test.NotContains(err.Error(), "no number")
+	}
+	test.True(crconfig.IsSecret("TEST_SECRET_NUM"))
+
+	// values composed of secrets are secret as well
+	os.Setenv("TEST_SECRET_PORT", "${TEST_SECRET_ENV}0")
+	defer os.Unsetenv("TEST_SECRET_PORT")
+	test.True(crconfig.IsSecret("TEST_SECRET_PORT"))
+	p := struct {
+		Port int `env:"TEST_SECRET_PORT"`
+	}{}
+	err = crconfig.Bind(&p)
+	if test.NotNil(err) {
+		test.NotContains(err.Error(), "env secret")
+	}
+}
+
 func TestBind(t *testing.T) {
 	type Data struct {
 		First    string  `env:"FIRST_VAL,katzenfurz"`
@@ -63,6 +237,69 @@ func TestBind(t *testing.T) {
 	test.EqualValues(99, d.Default2)
 }
 
+func TestBindTypes(t *testing.T) {
+	type Level int
+	type DB struct {
+		Host string `env:"HOST"`
+		Port uint16 `env:"PORT"`
+	}
+	type Data struct {
+		Origins []string       `env:"ALLOWED_ORIGINS"`
+		Timeout time.Duration  `env:"TIMEOUT"`
+		Ports   []int          `env:"PORTS"`
+		Limits  map[string]int `env:"LIMITS"`
+		IP      net.IP         `env:"SERVER_IP"`
+		Level   Level          `env:"NOT_THERE,3"`
+		Number  *int64         `env:"MY_NUMVBER"`
+		Missing *int64         `env:"NOT_THERE"`
+		DB      DB             `env:"DB_"`
+		Other   *DB            `env:"NOT_THERE_"`
+		Primary *DB            `env:"DB_"`
+	}
+
+	test := assert.New(t)
+
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	d := Data{}
+	err = crconfig.Bind(&d)
+	test.Nil(err)
+
+	test.Equal([]string{"a.com", "b.com", "c.com"}, d.Origins)
+	test.Equal(5*time.Second, d.Timeout)
+	test.Equal([]int{80, 443}, d.Ports)
+	test.Equal(map[string]int{"free": 10, "pro": 100}, d.Limits)
+	test.Equal("10.0.0.1", d.IP.String())
+	test.EqualValues(3, d.Level)
+	if test.NotNil(d.Number) {
+		test.EqualValues(42, *d.Number)
+	}
+	test.Nil(d.Missing)
+	test.Equal("db.local", d.DB.Host)
+	test.EqualValues(5432, d.DB.Port)
+	test.Nil(d.Other)
+	if test.NotNil(d.Primary) {
+		test.Equal("db.local", d.Primary.Host)
+	}
+
+	bad := struct {
+		Num int `env:"FIRST_VAL"`
+	}{}
+	test.NotNil(crconfig.Bind(&bad))
+
+	// explicit zero values are configured as well
+	t.Setenv("ZERO_HOST", "")
+	t.Setenv("ZERO_PORT", "0")
+	zero := struct {
+		DB *DB `env:"ZERO_"`
+	}{}
+	test.Nil(crconfig.Bind(&zero))
+	if test.NotNil(zero.DB) {
+		test.Equal(DB{}, *zero.DB)
+	}
+}
+
 func TestBindPartially(t *testing.T) {
 	type Data1 struct {
 		First  string `env:"FIRST_VAL"`
@@ -132,6 +369,317 @@ func TestSwitches(t *testing.T) {
 	}
 }
 
+func TestSetSwitches(t *testing.T) {
+	test := assert.New(t)
+
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	sws := []crconfig.Switch{
+		{Switch: "-v", Long: "--verbose", EnvKey: "TEST_VERBOSE", Description: "talk a lot", Bool: true},
+		{Switch: "-q", EnvKey: "TEST_QUIET", Bool: true},
+		{Switch: "-o", Long: "--output", EnvKey: "TEST_OUTPUT", Description: "output file"},
+		{Long: "--level", EnvKey: "TEST_LEVEL"},
+	}
+
+	os.Args = []string{"test_cmd", "run", "-vq", "--output=out.txt", "--level", "3", "-n", "7", "--", "-o", "x"}
+	test.Nil(crconfig.SetSwitches(sws...))
+	test.True(crconfig.GetBool("TEST_VERBOSE", false))
+	test.True(crconfig.GetBool("TEST_QUIET", false))
+	test.Equal("out.txt", crconfig.Get("TEST_OUTPUT", ""))
+	test.EqualValues(3, crconfig.GetInt("TEST_LEVEL", 0))
+	test.EqualValues(7, crconfig.GetInt("MY_NUMVBER", 0))
+	test.Equal([]string{"test_cmd", "run", "-o", "x"}, crconfig.Args())
+
+	os.Args = []string{"test_cmd", "--verbose=false", "-o=file"}
+	test.Nil(crconfig.SetSwitches())
+	test.False(crconfig.GetBool("TEST_VERBOSE", true))
+	test.Equal("file", crconfig.Get("TEST_OUTPUT", ""))
+
+	os.Args = []string{"test_cmd", "move", "--level", "-5", "-o", "-1.5", "-3"}
+	test.Nil(crconfig.SetSwitches())
+	test.EqualValues(-5, crconfig.GetInt("TEST_LEVEL", 0))
+	test.Equal("-1.5", crconfig.Get("TEST_OUTPUT", ""))
+	test.Equal([]string{"test_cmd", "move", "-3"}, crconfig.Args())
+
+	os.Args = []string{"test_cmd", "--unknown"}
+	test.NotNil(crconfig.SetSwitches())
+	os.Args = []string{"test_cmd", "-vx"}
+	test.NotNil(crconfig.SetSwitches())
+	os.Args = []string{"test_cmd", "--output"}
+	test.NotNil(crconfig.SetSwitches())
+
+	out := &bytes.Buffer{}
+	crconfig.HelpOutput = out
+	os.Args = []string{"test_cmd", "-h"}
+	test.Equal(crconfig.ErrHelp, crconfig.SetSwitches())
+	test.Contains(out.String(), "Usage: test_cmd [switches]")
+	test.Contains(out.String(), "-v, --verbose")
+	test.Contains(out.String(), "talk a lot (TEST_VERBOSE)")
+	test.Contains(out.String(), "-o, --output <value>")
+	test.Contains(out.String(), "-n <value>")
+	test.Contains(out.String(), "-h, --help")
+	crconfig.HelpOutput = os.Stdout
+
+	// unknown switches are ignored on Read
+	os.Args = []string{"test_cmd", "--unknown", "-n"}
+	test.Nil(crconfig.Read("testdata.env"))
+	test.EqualValues(42, crconfig.GetInt("MY_NUMVBER", 0))
+}
+
+func TestSchema(t *testing.T) {
+	type DB struct {
+		Host string `env:"HOST,localhost" desc:"database host"`
+		Pass string `env:"PASS" secret:"true" required:"true"`
+	}
+	type Config struct {
+		Origins []string      `env:"ALLOWED_ORIGINS,a,b" desc:"allowed origins"`
+		Timeout time.Duration `env:"TIMEOUT,5s"`
+		Port    int           `env:"PORT,80" required:"true"`
+		DB      DB            `env:"DB_"`
+		Ignored string
+	}
+
+	test := assert.New(t)
+
+	fields, err := crconfig.DescribeExclusive(&Config{})
+	test.Nil(err)
+	if test.Len(fields, 5) {
+		test.Equal("ALLOWED_ORIGINS", fields[0].Key)
+		test.Equal("list of string", fields[0].Type)
+		test.Equal("a,b", fields[0].Default)
+		test.Equal("duration", fields[1].Type)
+		test.True(fields[2].Required)
+		test.Equal("DB_HOST", fields[3].Key)
+		test.Equal("database host", fields[3].Description)
+		test.True(fields[4].Secret)
+	}
+
+	fields, err = crconfig.Describe(Config{})
+	test.Nil(err)
+	test.Len(fields, 6)
+
+	md := crconfig.Markdown(fields)
+	test.Contains(md, "| `DB_HOST` | string | `localhost` |  | database host |")
+	test.Contains(md, "| `DB_PASS` | string |  | yes | *(secret)* |")
+
+	env := crconfig.SampleEnv(fields)
+	test.Contains(env, "# allowed origins (list of string)\nALLOWED_ORIGINS=a,b\n")
+	test.Contains(env, "# (string, required, secret)\nDB_PASS=\n")
+
+	js, err := crconfig.JSONSchema(fields)
+	test.Nil(err)
+	schema := struct {
+		Properties map[string]map[string]interface{}
+		Required   []string
+	}{}
+	test.Nil(json.Unmarshal(js, &schema))
+	test.Equal([]string{"PORT", "DB_PASS"}, schema.Required)
+	test.Equal("array", schema.Properties["ALLOWED_ORIGINS"]["type"])
+	test.Equal([]interface{}{"a", "b"}, schema.Properties["ALLOWED_ORIGINS"]["default"])
+	test.Equal("integer", schema.Properties["PORT"]["type"])
+	test.EqualValues(80, schema.Properties["PORT"]["default"])
+
+	err = crconfig.Read("testdata.env")
+	test.Nil(err)
+	test.NotNil(crconfig.Bind(&Config{}), "DB_PASS is required")
+}
+
+func TestDump(t *testing.T) {
+	test := assert.New(t)
+
+	os.Args = []string{"test_cmd", "-n", "5"}
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	os.Setenv("FIRST_VAL", "from env")
+	os.Setenv("TEST_DUMPED_PASS", "hunter2")
+	os.Setenv("TEST_DUMPED_URL", "postgres://app:${TEST_DUMPED_PASS}@db/app")
+	defer func() {
+		for _, k := range []string{"FIRST_VAL", "TEST_DUMPED_PASS", "TEST_DUMPED_URL"} {
+			os.Unsetenv(k)
+		}
+	}()
+	crconfig.MarkSecret("TRY_FLOAT")
+
+	d := struct {
+		Dumped string `env:"TEST_DUMPED,dumpy"`
+		Pass   string `env:"TEST_DUMPED_PASS" secret:"true"`
+		URL    string `env:"TEST_DUMPED_URL"`
+	}{}
+	test.Nil(crconfig.Bind(&d))
+
+	text, err := crconfig.Dump("text")
+	test.Nil(err)
+	test.Regexp(`MY_NUMVBER +\= 5 \(cli\)`, text)
+	test.Regexp(`FIRST_VAL +\= from env \(env\)`, text)
+	test.Regexp(`COPY_VALUE1 +\= Moinsen! \(file\)`, text)
+	test.Regexp(`TEST_DUMPED +\= dumpy \(default\)`, text)
+	test.Regexp(`TRY_FLOAT +\= \*\*\*\*\*\* \(file\)`, text)
+	test.NotContains(text, "3.45")
+	test.Regexp(`TEST_DUMPED_URL +\= \*\*\*\*\*\* \(env\)`, text)
+	test.NotContains(text, "hunter2")
+
+	js, err := crconfig.Dump("json")
+	test.Nil(err)
+	var entries []crconfig.Entry
+	test.Nil(json.Unmarshal([]byte(js), &entries))
+	test.Contains(entries, crconfig.Entry{Key: "TRY_FLOAT", Value: crconfig.Redacted, Source: crconfig.LayerFile})
+
+	_, err = crconfig.Dump("yaml")
+	test.NotNil(err)
+
+	f, err := ioutil.TempFile("", "crconfig")
+	test.Nil(err)
+	defer os.Remove(f.Name())
+	f.WriteString("FIRST_VAL=this is the first value\nMY_NUMVBER=43\nTRY_FLOAT=1.1\nNEW_ONE=new\n")
+	f.Close()
+
+	changes, err := crconfig.DiffFiles("testdata.env", f.Name(), "")
+	test.Nil(err)
+	diff := map[string]string{}
+	for _, c := range changes {
+		diff[c.Key] = c.String()
+	}
+	test.NotContains(diff, "FIRST_VAL")
+	test.Equal("~ MY_NUMVBER: 42 -> 43", diff["MY_NUMVBER"])
+	test.Equal("~ TRY_FLOAT: ****** -> ******", diff["TRY_FLOAT"])
+	test.Equal("+ NEW_ONE=new", diff["NEW_ONE"])
+	test.Equal("- WORKS_GREAT=true", diff["WORKS_GREAT"])
+
+	data, err := json.Marshal(changes)
+	test.Nil(err)
+	test.Contains(string(data), `{"key":"NEW_ONE","to":"new","added":true}`)
+	test.Contains(string(data), `{"key":"WORKS_GREAT","from":"true","removed":true}`)
+
+	changes = crconfig.DiffEnviron([]string{"A=1", "B=2"}, []string{"A=1", "B=3"})
+	test.Equal([]crconfig.Change{{Key: "B", From: "2", To: "3"}}, changes)
+
+	changes = crconfig.DiffEnviron([]string{"P=secret:env:X", "U=a"}, []string{"P=secret:env:X", "U=${P}@b"})
+	test.Equal([]crconfig.Change{{Key: "U", From: "a", To: crconfig.Redacted}}, changes)
+}
+
+func TestProfiles(t *testing.T) {
+	test := assert.New(t)
+
+	{ // sections, no profile
+		os.Args = []string{"test_cmd"}
+		test.Nil(crconfig.Read("testdata/profiles.env"))
+		test.Equal("", crconfig.Profile())
+		test.Equal("localhost", crconfig.Get("DB_HOST", ""))
+		test.Equal("debug", crconfig.Get("LOG_LEVEL", ""))
+	}
+
+	{ // sections, profile by switch
+		os.Args = []string{"test_cmd", "--profile", "prod", "-l", "error"}
+		test.Nil(crconfig.Read("testdata/profiles.env"))
+		test.Equal("prod", crconfig.Profile())
+		test.Equal("profiled", crconfig.Get("APP_NAME", ""))
+		test.Equal("db.prod.local", crconfig.Get("DB_HOST", ""))
+		test.Equal("error", crconfig.Get("LOG_LEVEL", ""))
+	}
+
+	{ // sections, profile by env
+		os.Args = []string{"test_cmd"}
+		os.Setenv("APP_PROFILE", "dev")
+		test.Nil(crconfig.Read("testdata/profiles.env"))
+		test.Equal("dev", crconfig.Profile())
+		test.Equal("db.dev.local", crconfig.Get("DB_HOST", ""))
+		test.Equal("debug", crconfig.Get("LOG_LEVEL", ""))
+
+		os.Setenv("APP_PROFILE", "stage")
+		test.NotNil(crconfig.Read("testdata/profiles.env"))
+		os.Unsetenv("APP_PROFILE")
+	}
+
+	{ // directory
+		os.Args = []string{"test_cmd", "--profile=prod"}
+		test.Nil(crconfig.Read("testdata/profiles"))
+		test.Equal("profiled", crconfig.Get("APP_NAME", ""))
+		test.Equal("db.prod.local", crconfig.Get("DB_HOST", ""))
+		test.Equal("warn", crconfig.Get("LOG_LEVEL", ""))
+
+		os.Args = []string{"test_cmd"}
+		test.Nil(crconfig.Read("testdata/profiles"))
+		test.Equal("localhost", crconfig.Get("DB_HOST", ""))
+
+		os.Args = []string{"test_cmd", "--profile=stage"}
+		test.NotNil(crconfig.Read("testdata/profiles"))
+	}
+
+	values, err := crconfig.ParseFile("testdata/profiles.env", "prod")
+	test.Nil(err)
+	test.Equal(map[string]string{"APP_NAME": "profiled", "DB_HOST": "db.prod.local", "LOG_LEVEL": "warn"}, values)
+
+	// the profile of the process doesn't matter for parsing
+	os.Args = []string{"test_cmd", "--profile=prod"}
+	values, err = crconfig.ParseFile("testdata/profiles.env", "")
+	test.Nil(err)
+	test.Equal("localhost", values["DB_HOST"])
+
+	// no profiles, no switch
+	os.Args = []string{"test_cmd"}
+	os.Setenv("APP_PROFILE", "prod")
+	test.Nil(crconfig.Read("testdata.env"))
+	os.Unsetenv("APP_PROFILE")
+	test.Equal("", crconfig.Profile())
+	test.NotContains(crconfig.Usage(), "--profile")
+}
+
+func TestDotenv(t *testing.T) {
+	test := assert.New(t)
+
+	os.Args = []string{"test_cmd"}
+	crconfig.Strict = true
+	defer func() {
+		crconfig.Strict = false
+	}()
+
+	test.Nil(crconfig.Read("testdata/dotenv.env"))
+	test.Equal("exported value", crconfig.Get("EXPORTED", ""))
+	test.Equal("some value", crconfig.Get("INLINE", ""))
+	test.Equal("value#no comment", crconfig.Get("HASH", ""))
+	test.Equal("a b # not comment", crconfig.Get("DOUBLE", ""))
+	test.Equal("line1\nline2\t\"quoted\" \\ ${LITERAL}", crconfig.Get("ESCAPED", ""))
+	test.Equal("single ${NOT_INTERPOLATED} \\n", crconfig.Get("SINGLE", ""))
+	test.Equal("", crconfig.Get("EMPTY", "default"))
+	test.Equal("exported value!", crconfig.Get("INTERPOLATED", ""))
+
+	err := crconfig.Read("testdata/malformed.env")
+	if pe, ok := err.(*crconfig.ParseError); test.True(ok, "ParseError") {
+		test.Equal("testdata/malformed.env", pe.File)
+		test.Equal(2, pe.Line)
+		test.Equal("testdata/malformed.env:2: missing '=' in line", pe.Error())
+	}
+
+	lines := []string{}
+	for _, content := range []string{"-x\n", "A=1\nA=2\n", "BAD KEY=1\n", "Q=\"open\n", "Q=\"closed\" text\n", "[broken\n"} {
+		f, err := ioutil.TempFile("", "crconfig")
+		test.Nil(err)
+		f.WriteString(content)
+		f.Close()
+		err = crconfig.Read(f.Name())
+		os.Remove(f.Name())
+		if test.NotNil(err, content) {
+			lines = append(lines, err.Error()[len(f.Name())+1:])
+		}
+	}
+	test.Equal([]string{
+		"1: switch -x without key",
+		"2: duplicate key A, first on line 1",
+		"1: invalid key \"BAD KEY\"",
+		"1: missing closing '\"' for Q",
+		"1: unexpected text after quoted value of Q",
+		"1: malformed section [broken",
+	}, lines)
+
+	crconfig.Strict = false
+	test.Nil(crconfig.Read("testdata/malformed.env"))
+	test.Equal("again", crconfig.Get("GOOD", ""))
+	test.Equal("value", crconfig.Get("BAD KEY", ""))
+	test.Equal("\"not closed", crconfig.Get("QUOTE", ""))
+}
+
 func TestVariables(t *testing.T) {
 	test := assert.New(t)
 
@@ -168,4 +716,34 @@ func TestVariables(t *testing.T) {
 		test.Equal("barse", crconfig.Get("COPY_VALUE2", ""), "COPY_VALUE2")
 		test.Equal("barse", crconfig.Get("WEIRD_VALUE", ""), "WEIRD_VALUE")
 	}
+
+	{ // no variables
+		os.Args = []string{"test_cmd"}
+
+		err := crconfig.Read("testdata.env")
+		test.Nil(err)
+
+		test.Equal("BASE_VALUE", crconfig.Get("NO_COPY", ""), "NO_COPY")
+		test.Equal("Moinsen! said nobody, ${MY_NUMVBER} is 42$", crconfig.Get("GREETING", ""), "GREETING")
+	}
+
+	{ // cycles
+		os.Setenv("TEST_CYCLE_A", "${TEST_CYCLE_B}")
+		os.Setenv("TEST_CYCLE_B", "x${TEST_CYCLE_A}")
+		defer os.Unsetenv("TEST_CYCLE_A")
+		defer os.Unsetenv("TEST_CYCLE_B")
+
+		_, found, err := crconfig.Lookup("TEST_CYCLE_A")
+		test.True(found)
+		test.NotNil(err)
+		test.Equal("def", crconfig.Get("TEST_CYCLE_B", "def"))
+
+		f, err := ioutil.TempFile("", "crconfig")
+		test.Nil(err)
+		defer os.Remove(f.Name())
+		f.WriteString("A=${B}\nB=${A}\n")
+		f.Close()
+
+		test.NotNil(crconfig.Read(f.Name()))
+	}
 }
@@ -0,0 +1,202 @@
+package crconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Entry is one resolved config value, as given by Entries
+	Entry struct {
+		Key    string `json:"key"`
+		Value  string `json:"value"`
+		Source string `json:"source"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	// Change is one difference found by Diff
+	Change struct {
+		Key string `json:"key"`
+		// From is the value before, empty if the key was added
+		From string `json:"from,omitempty"`
+		// To is the value after, empty if the key was removed
+		To string `json:"to,omitempty"`
+		// Added key
+		Added bool `json:"added,omitempty"`
+		// Removed key
+		Removed bool `json:"removed,omitempty"`
+	}
+)
+
+var (
+	defaultMutex sync.RWMutex
+	defaults     = map[string]string{}
+)
+
+// setDefault remembers the default of a bound key, to be shown by Dump
+func setDefault(key, def string) {
+	defaultMutex.Lock()
+	defaults[key] = def
+	defaultMutex.Unlock()
+}
+
+// Entries returns all known keys with their resolved values and the layer they come from, sorted by key.
+// Known keys are the ones from config file, remote, command line switches and keys bound by Bind.
+// Environment variables are only taken into account for known keys.
+// Values of secret keys are redacted.
+func Entries() []Entry {
+	keys := map[string]bool{}
+	for key := range conf {
+		keys[key] = true
+	}
+	for key := range cli {
+		keys[key] = true
+	}
+	if r := currentRemote(); r != nil {
+		for _, key := range r.keys() {
+			keys[key] = true
+		}
+	}
+	defaultMutex.RLock()
+	for key := range defaults {
+		keys[key] = true
+	}
+	defaultMutex.RUnlock()
+
+	res := make([]Entry, 0, len(keys))
+	for key := range keys {
+		e := Entry{Key: key}
+		_, e.Source = find(key)
+		if e.Source == "" {
+			defaultMutex.RLock()
+			e.Value, e.Source = defaults[key], LayerDefault
+			defaultMutex.RUnlock()
+		} else if val, _, err := Lookup(key); err != nil {
+			e.Error = err.Error()
+		} else {
+			e.Value = val
+		}
+		e.Value = Redact(key, e.Value)
+		res = append(res, e)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+// Dump returns all Entries as "text" or "json", with secrets redacted.
+func Dump(format string) (string, error) {
+	entries := Entries()
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(entries, "", "  ")
+		return string(data), err
+	case "text", "":
+		width := 0
+		for _, e := range entries {
+			if len(e.Key) > width {
+				width = len(e.Key)
+			}
+		}
+		var b strings.Builder
+		for _, e := range entries {
+			val := e.Value
+			if e.Error != "" {
+				val = "<" + e.Error + ">"
+			}
+			fmt.Fprintf(&b, "%-*s = %s (%s)\n", width, e.Key, val, e.Source)
+		}
+		return b.String(), nil
+	}
+
+	return "", fmt.Errorf("unknown dump format %s", format)
+}
+
+// Diff compares two configs, e.g. read by ParseFile, and returns the changes from a to b, sorted by key.
+// Values of secret keys are redacted, also the ones composed of secrets by ${KEY} in a, b or the current config.
+func Diff(a, b map[string]string) []Change {
+	var res []Change
+
+	for key, from := range a {
+		to, ok := b[key]
+		switch {
+		case !ok:
+			res = append(res, Change{Key: key, From: redactRaw(a, key, from), Removed: true})
+		case from != to:
+			res = append(res, Change{Key: key, From: redactRaw(a, key, from), To: redactRaw(b, key, to)})
+		}
+	}
+	for key, to := range b {
+		if _, ok := a[key]; !ok {
+			res = append(res, Change{Key: key, To: redactRaw(b, key, to), Added: true})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+// DiffFiles compares two config files for the given profile, see Diff
+func DiffFiles(a, b, profile string) ([]Change, error) {
+	ma, err := ParseFile(a, profile)
+	if err != nil {
+		return nil, err
+	}
+	mb, err := ParseFile(b, profile)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(ma, mb), nil
+}
+
+// DiffEnviron compares two environments in the form of os.Environ(), see Diff
+func DiffEnviron(a, b []string) []Change {
+	return Diff(environMap(a), environMap(b))
+}
+
+func environMap(environ []string) map[string]string {
+	res := map[string]string{}
+	for _, e := range environ {
+		if pair := strings.SplitN(e, "=", 2); len(pair) > 1 {
+			res[pair[0]] = pair[1]
+		}
+	}
+	return res
+}
+
+// redactRaw redacts val of key in m, if key is secret in m or the current config.
+// A value referencing a secret provider is kept, the reference itself is no secret.
+func redactRaw(m map[string]string, key, val string) string {
+	if _, _, ok := provider(val); ok {
+		return val
+	}
+	lookup := func(k string) (string, bool) {
+		if v, ok := m[k]; ok {
+			return v, true
+		}
+		return rawLookup(k)
+	}
+	if isSecret(key, lookup, map[string]bool{}) || IsSecret(key) {
+		return Redacted
+	}
+	return val
+}
+
+// String formats the change like "+ KEY=value", "- KEY=value" or "~ KEY: from -> to"
+func (c Change) String() string {
+	switch {
+	case c.Added:
+		return "+ " + c.Key + "=" + c.To
+	case c.Removed:
+		return "- " + c.Key + "=" + c.From
+	}
+	return "~ " + c.Key + ": " + c.From + " -> " + c.To
+}
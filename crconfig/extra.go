@@ -1,60 +1,275 @@
 package crconfig
 
 import (
+	"encoding"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
 
 // Bind binds all found values into given struct.
-// Supported types are string, bool and int, float in all bitdepths
+// Supported types are string, bool, int, uint and float in all bitdepths (also as named types),
+// time.Duration, url.URL, slices, maps, pointers, nested structs and implementations of encoding.TextUnmarshaler.
 func Bind(obj interface{}) error {
 	return bind(obj, false)
 
 }
 
 // BindExclusive binds only env tagged values into given struct.
-// Supported types are string, bool and int, float in all bitdepths
+// Supported types are the same as for Bind.
 func BindExclusive(obj interface{}) error {
 	return bind(obj, true)
 }
 
 func bind(obj interface{}, exclusive bool) error {
-	v := reflect.ValueOf(obj).Elem()
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can not set data to given obj")
+	}
+	v = v.Elem()
 	if !v.CanSet() {
 		return fmt.Errorf("can not set data to given obj")
 	}
 
+	_, err := walk(v, "", exclusive, func(f Field, field reflect.Value) (bool, error) {
+		if f.Secret {
+			MarkSecret(f.Key)
+		}
+		setDefault(f.Key, f.Default)
+
+		val, found, err := Lookup(f.Key)
+		if err != nil {
+			return false, fmt.Errorf("can not bind %s", err.Error())
+		}
+		if !found {
+			if f.Required {
+				return false, fmt.Errorf("can not bind %s: required but not set", f.Key)
+			}
+			val = f.Default
+		}
+		set := found || f.Default != ""
+		if val == "" {
+			field.Set(reflect.Zero(field.Type()))
+			return set, nil
+		}
+		if err := setValue(field, val); err != nil {
+			return false, fmt.Errorf("can not bind %s", redactErr(f.Key, val, err).Error())
+		}
+		return set, nil
+	})
+	return err
+}
+
+// walk calls fn for every bindable field of struct v, where prefix is put before every key.
+// Nested structs are walked as well, using their tag as prefix.
+// fn tells whether the field got a value from the config or a default, walk whether any field did.
+func walk(v reflect.Value, prefix string, exclusive bool, fn func(Field, reflect.Value) (bool, error)) (bool, error) {
+	anySet := false
 	t := v.Type()
 
 	for i := 0; i < t.NumField(); i++ {
 		fieldt := t.Field(i)
+		if fieldt.PkgPath != "" {
+			continue // unexported
+		}
+
 		name := fieldt.Name
 		def := "" // default value?
-		if tag := fieldt.Tag.Get("env"); tag != "" {
+		tag := fieldt.Tag.Get("env")
+		if tag != "" {
 			name = tag
 			if parts := strings.SplitN(tag, ",", 2); len(parts) > 1 {
 				name = parts[0]
 				def = parts[1]
 			}
-		} else if exclusive {
+		}
+
+		field := v.Field(i)
+
+		// nested structs use the tag as prefix for their keys
+		if isNested(fieldt.Type) {
+			nested := prefix
+			if tag != "" {
+				nested += name
+			}
+			if fieldt.Type.Kind() == reflect.Ptr {
+				if !field.IsNil() {
+					field = field.Elem()
+				} else {
+					// allocated only if a field below got a value or default, even a zero one like PORT=0
+					p := reflect.New(fieldt.Type.Elem())
+					set, err := walk(p.Elem(), nested, exclusive, fn)
+					if err != nil {
+						return false, err
+					}
+					if set {
+						field.Set(p)
+						anySet = true
+					}
+					continue
+				}
+			}
+			set, err := walk(field, nested, exclusive, fn)
+			if err != nil {
+				return false, err
+			}
+			anySet = anySet || set
 			continue
 		}
 
-		switch {
-		case fieldt.Type.Kind() == reflect.String:
-			v.Field(i).SetString(Get(name, def))
-		case fieldt.Type.Kind() == reflect.Bool:
-			v.Field(i).SetBool(GetBool(name, (strings.ToLower(def) == "true")))
-		case strings.HasPrefix(fieldt.Type.Name(), "int"):
-			val, _ := strconv.ParseInt(def, 10, 64)
-			v.Field(i).SetInt(GetInt(name, val))
-		case strings.HasPrefix(fieldt.Type.Name(), "float"):
-			val, _ := strconv.ParseFloat(def, 64)
-			v.Field(i).SetFloat(GetFloat(name, val))
+		if (tag == "" && exclusive) || !isSupported(fieldt.Type) {
+			continue
+		}
+
+		f := Field{
+			Key:         prefix + name,
+			Type:        typeName(fieldt.Type),
+			Default:     def,
+			Description: fieldt.Tag.Get("desc"),
+			Required:    fieldt.Tag.Get("required") == "true",
+			Secret:      fieldt.Tag.Get("secret") == "true",
+			goType:      fieldt.Type,
+		}
+		set, err := fn(f, field)
+		if err != nil {
+			return false, err
+		}
+		anySet = anySet || set
+	}
+
+	return anySet, nil
+}
+
+// isNested tells whether t is a struct (or pointer to one) to be bound field by field
+func isNested(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType) && t != urlType
+}
+
+// isSupported tells whether a value of type t can be set by setValue
+func isSupported(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) || t == urlType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice, reflect.Ptr:
+		return isSupported(t.Elem())
+	case reflect.Map:
+		return isSupported(t.Key()) && isSupported(t.Elem())
+	}
+	return false
+}
+
+// setValue parses val into v, according to the type of v.
+// Slices are comma separated, maps are comma separated key:value pairs.
+func setValue(v reflect.Value, val string) error {
+	t := v.Type()
+
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val))
+	}
+
+	if t == urlType {
+		u, err := url.Parse(val)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	if t == durationType {
+		d, err := ParseDuration(val, time.Nanosecond)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, err := parseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, t.Bits())
+		if err != nil {
+			return err
 		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if err := setValue(p.Elem(), val); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(val))
+			return nil
+		}
+		parts := strings.Split(val, ",")
+		s := reflect.MakeSlice(t, len(parts), len(parts))
+		for i, part := range parts {
+			if err := setValue(s.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(t)
+		for _, part := range strings.Split(val, ",") {
+			pair := strings.SplitN(part, ":", 2)
+			if len(pair) < 2 {
+				return fmt.Errorf("missing ':' in map entry %q", part)
+			}
+			k := reflect.New(t.Key()).Elem()
+			if err := setValue(k, strings.TrimSpace(pair[0])); err != nil {
+				return err
+			}
+			e := reflect.New(t.Elem()).Elem()
+			if err := setValue(e, strings.TrimSpace(pair[1])); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", t)
 	}
 
 	return nil
@@ -65,15 +280,15 @@ func bind(obj interface{}, exclusive bool) error {
 func GetWithPrefix(prefix string) map[string]string {
 	res := map[string]string{}
 
-	for key, val := range cli {
+	for key := range cli {
 		if strings.HasPrefix(key, prefix) {
-			res[key] = val
+			res[key] = ""
 		}
 	}
 
-	for key, val := range conf {
+	for key := range conf {
 		if strings.HasPrefix(key, prefix) {
-			res[key] = val
+			res[key] = ""
 		}
 	}
 
@@ -81,9 +296,13 @@ func GetWithPrefix(prefix string) map[string]string {
 		pair := strings.SplitN(e, "=", 2)
 		key := pair[0]
 		if strings.HasPrefix(key, prefix) {
-			res[key] = pair[1]
+			res[key] = ""
 		}
 	}
 
+	for key := range res {
+		res[key] = Get(key, "")
+	}
+
 	return res
 }
@@ -0,0 +1,121 @@
+package crconfig
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Flag is a feature flag, parsed from a config value by ParseFlag.
+type Flag struct {
+	// Percent of subjects the flag is enabled for, 100 means on, 0 off.
+	Percent float64
+	// Allow has subjects the flag is always enabled for
+	Allow map[string]bool
+	// Deny has subjects the flag is never enabled for
+	Deny map[string]bool
+}
+
+var (
+	flagMutex sync.Mutex
+	flags     = map[string]*Flag{}
+)
+
+func init() {
+	OnReload(func() {
+		flagMutex.Lock()
+		flags = map[string]*Flag{}
+		flagMutex.Unlock()
+	})
+}
+
+// Enabled tells whether the feature flag key is enabled for subjectID, e.g. a user or customer id.
+// Invalid or missing flags are disabled. See ParseFlag for the format.
+func Enabled(key, subjectID string) bool {
+	val, _, err := Lookup(key)
+	if err != nil {
+		return false
+	}
+
+	flagMutex.Lock()
+	f, ok := flags[val]
+	flagMutex.Unlock()
+	if !ok {
+		if f, err = ParseFlag(val); err != nil {
+			return false
+		}
+		flagMutex.Lock()
+		flags[val] = f
+		flagMutex.Unlock()
+	}
+
+	return f.Enabled(key, subjectID)
+}
+
+// ParseFlag parses a feature flag. It consists of rules separated by ';':
+//
+//	on, true       enabled for everybody
+//	off, false     disabled for everybody (default)
+//	25%            enabled for 25% of all subjects, stable for each subject
+//	allow:42,43    always enabled for subjects 42 and 43
+//	deny:7         never enabled for subject 7
+//
+// E.g. "allow:42; 10%" is enabled for 42 and 10% of everybody else.
+func ParseFlag(val string) (*Flag, error) {
+	f := &Flag{Allow: map[string]bool{}, Deny: map[string]bool{}}
+
+	for _, rule := range strings.Split(val, ";") {
+		rule = strings.TrimSpace(rule)
+		lower := strings.ToLower(rule)
+		switch {
+		case rule == "":
+		case lower == "on" || lower == "true":
+			f.Percent = 100
+		case lower == "off" || lower == "false":
+			f.Percent = 0
+		case strings.HasSuffix(rule, "%"):
+			p, err := strconv.ParseFloat(strings.TrimSpace(rule[:len(rule)-1]), 64)
+			if err != nil || p < 0 || p > 100 {
+				return nil, fmt.Errorf("invalid percentage %q", rule)
+			}
+			f.Percent = p
+		case strings.HasPrefix(lower, "allow:"):
+			addSubjects(f.Allow, rule[len("allow:"):])
+		case strings.HasPrefix(lower, "deny:"):
+			addSubjects(f.Deny, rule[len("deny:"):])
+		default:
+			return nil, fmt.Errorf("invalid flag rule %q", rule)
+		}
+	}
+
+	return f, nil
+}
+
+func addSubjects(m map[string]bool, list string) {
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			m[s] = true
+		}
+	}
+}
+
+// Enabled tells whether the flag named key is enabled for subjectID.
+// The name is part of the hash, so the same subject gets different results for different flags.
+func (f *Flag) Enabled(key, subjectID string) bool {
+	switch {
+	case f.Deny[subjectID]:
+		return false
+	case f.Allow[subjectID]:
+		return true
+	case f.Percent >= 100:
+		return true
+	case f.Percent <= 0:
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key + ":" + subjectID))
+	return float64(h.Sum32()%10000) < f.Percent*100
+}
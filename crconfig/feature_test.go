@@ -0,0 +1,52 @@
+package crconfig_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"cleverreach.com/crtools/crconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	test := assert.New(t)
+
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	os.Setenv("TEST_FEATURE", "allow:vip, boss; deny:troll; 30%")
+	defer os.Unsetenv("TEST_FEATURE")
+
+	test.True(crconfig.Enabled("TEST_FEATURE", "vip"))
+	test.True(crconfig.Enabled("TEST_FEATURE", "boss"))
+	test.False(crconfig.Enabled("TEST_FEATURE", "troll"))
+
+	count := 0
+	for i := 0; i < 10000; i++ {
+		id := strconv.Itoa(i)
+		enabled := crconfig.Enabled("TEST_FEATURE", id)
+		test.Equal(enabled, crconfig.Enabled("TEST_FEATURE", id), "stable")
+		if enabled {
+			count++
+		}
+	}
+	test.InDelta(3000, count, 200)
+
+	os.Setenv("TEST_FEATURE", "on")
+	test.True(crconfig.Enabled("TEST_FEATURE", "1"))
+	os.Setenv("TEST_FEATURE", "false")
+	test.False(crconfig.Enabled("TEST_FEATURE", "1"))
+	os.Setenv("TEST_FEATURE", "deny:1; on")
+	test.False(crconfig.Enabled("TEST_FEATURE", "1"))
+	test.True(crconfig.Enabled("TEST_FEATURE", "2"))
+	os.Setenv("TEST_FEATURE", "maybe")
+	test.False(crconfig.Enabled("TEST_FEATURE", "1"))
+	test.False(crconfig.Enabled("NOT_THERE", "1"))
+
+	_, err = crconfig.ParseFlag("120%")
+	test.NotNil(err)
+	f, err := crconfig.ParseFlag("12.5%")
+	test.Nil(err)
+	test.Equal(12.5, f.Percent)
+}
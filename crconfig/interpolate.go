@@ -0,0 +1,132 @@
+package crconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// variableError is returned on syntax errors and cycles in variables
+type variableError string
+
+func (e variableError) Error() string {
+	return string(e)
+}
+
+// interpolate replaces ${KEY} and ${KEY:-default} in val by the value of KEY.
+// The default is used if KEY is not set or empty. $${ is kept as literal ${.
+// path holds the keys being interpolated, to detect cycles.
+func interpolate(val string, path []string) (string, error) {
+	if !strings.Contains(val, "${") {
+		return val, nil
+	}
+
+	var res strings.Builder
+	for i := 0; i < len(val); i++ {
+		if val[i] != '$' || i+1 >= len(val) {
+			res.WriteByte(val[i])
+			continue
+		}
+		if strings.HasPrefix(val[i+1:], "${") {
+			res.WriteString("${")
+			i += 2
+			continue
+		}
+		if val[i+1] != '{' {
+			res.WriteByte(val[i])
+			continue
+		}
+
+		end := closingBrace(val, i+2)
+		if end < 0 {
+			return "", variableError(fmt.Sprintf("%s: missing '}' in variable", path[len(path)-1]))
+		}
+		expr := val[i+2 : end]
+		i = end
+
+		name, def, hasDef := expr, "", false
+		if pos := strings.Index(expr, ":-"); pos >= 0 {
+			name, def, hasDef = expr[:pos], expr[pos+2:], true
+		}
+		name = strings.TrimSpace(name)
+
+		for _, p := range path {
+			if p == name {
+				return "", variableError(fmt.Sprintf("cycle in variables: %s -> %s", strings.Join(path, " -> "), name))
+			}
+		}
+
+		ref, err := lookupPath(name, append(path, name))
+		if err != nil {
+			return "", err
+		}
+		if ref == "" && hasDef {
+			if ref, err = interpolate(def, path); err != nil {
+				return "", err
+			}
+		}
+		res.WriteString(ref)
+	}
+
+	return res.String(), nil
+}
+
+// lookupPath gets the fully resolved value of key, where path is the chain of keys leading here
+func lookupPath(key string, path []string) (string, error) {
+	val, ok := rawLookup(key)
+	if !ok {
+		return "", nil
+	}
+	val, err := interpolate(val, path)
+	if err != nil {
+		return "", err
+	}
+	return resolve(key, val)
+}
+
+// variables returns the keys referenced by ${KEY} in val, including the ones in defaults
+func variables(val string) []string {
+	var keys []string
+	for i := 0; i < len(val); i++ {
+		if val[i] != '$' || i+1 >= len(val) {
+			continue
+		}
+		if strings.HasPrefix(val[i+1:], "${") {
+			i += 2
+			continue
+		}
+		if val[i+1] != '{' {
+			continue
+		}
+		end := closingBrace(val, i+2)
+		if end < 0 {
+			break
+		}
+		expr := val[i+2 : end]
+		i = end
+
+		name, def := expr, ""
+		if pos := strings.Index(expr, ":-"); pos >= 0 {
+			name, def = expr[:pos], expr[pos+2:]
+		}
+		keys = append(keys, strings.TrimSpace(name))
+		keys = append(keys, variables(def)...)
+	}
+	return keys
+}
+
+// closingBrace finds the '}' matching the '${' before start
+func closingBrace(val string, start int) int {
+	depth := 1
+	for i := start; i < len(val); i++ {
+		switch val[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
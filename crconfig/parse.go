@@ -0,0 +1,211 @@
+package crconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Strict makes Read report malformed lines, switches without key and duplicate keys as *ParseError.
+// Otherwise they are skipped silently.
+var Strict bool
+
+// ParseError is a syntax error in a config file, reported in Strict mode.
+type ParseError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// parseFile parses the key/values and switches from file, by section.
+// The section "" holds everything before the first section.
+func parseFile(file string) (values map[string]map[string]string, sws map[string][]Switch, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return parse(f, file, true)
+}
+
+// parse parses the config in r, where name is used in errors.
+// If vars, the values are interpolated later, so literal parts like single quoted values get escaped.
+func parse(r io.Reader, name string, vars bool) (values map[string]map[string]string, sws map[string][]Switch, err error) {
+	section := ""
+	values = map[string]map[string]string{section: {}}
+	sws = map[string][]Switch{}
+	seen := map[string]map[string]int{section: {}} // line of each key, by section
+
+	fail := func(line int, format string, a ...interface{}) error {
+		if Strict {
+			return &ParseError{File: name, Line: line, Msg: fmt.Sprintf(format, a...)}
+		}
+		return nil
+	}
+
+	scan := bufio.NewScanner(r)
+	for num := 1; scan.Scan(); num++ {
+		line := strings.TrimSpace(scan.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+
+		case line[0] == '[':
+			if len(line) < 3 || line[len(line)-1] != ']' {
+				if err := fail(num, "malformed section %s", line); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if values[section] == nil {
+				values[section] = map[string]string{}
+				seen[section] = map[string]int{}
+			}
+
+		case line[0] == '-':
+			// -switch ENV_KEY optional description
+			parts := strings.Fields(line)
+			if len(parts) < 2 {
+				if err := fail(num, "switch %s without key", parts[0]); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			sws[section] = append(sws[section], Switch{
+				Switch:      parts[0],
+				EnvKey:      parts[1],
+				Description: strings.Join(parts[2:], " "),
+			})
+
+		default:
+			key, val, msg := parseLine(line, vars)
+			if msg != "" {
+				if err := fail(num, msg); err != nil {
+					return nil, nil, err
+				}
+				if key == "" {
+					continue
+				}
+			}
+			if first, dup := seen[section][key]; dup {
+				if err := fail(num, "duplicate key %s, first on line %d", key, first); err != nil {
+					return nil, nil, err
+				}
+			}
+			seen[section][key] = num
+			values[section][key] = val
+		}
+	}
+
+	return values, sws, scan.Err()
+}
+
+// parseLine parses a line like "KEY=value" with dotenv semantics:
+// an optional "export " prefix, values in double quotes with escape sequences,
+// values in single quotes taken literally and inline comments starting with " #" on unquoted values.
+// msg is set, if the line is malformed. key and val are set as good as possible anyway.
+func parseLine(line string, vars bool) (key, val, msg string) {
+	line = strings.TrimPrefix(line, "export ")
+	pos := strings.Index(line, "=")
+	if pos < 0 {
+		return "", "", "missing '=' in line"
+	}
+
+	key = strings.TrimSpace(line[:pos])
+	raw := strings.TrimSpace(line[pos+1:])
+	if !validKey(key) {
+		return key, raw, fmt.Sprintf("invalid key %q", key)
+	}
+	if raw == "" {
+		return key, "", ""
+	}
+
+	var rest string
+	switch raw[0] {
+	case '"':
+		var ok bool
+		if val, rest, ok = unquote(raw[1:], vars); !ok {
+			return key, raw, "missing closing '\"' for " + key
+		}
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return key, raw, "missing closing \"'\" for " + key
+		}
+		val, rest = raw[1:end+1], raw[end+2:]
+		if vars {
+			val = strings.ReplaceAll(val, "${", "$${")
+		}
+	default:
+		val = raw
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '#' && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+				val = strings.TrimSpace(raw[:i])
+				break
+			}
+		}
+		return key, val, ""
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return key, val, "unexpected text after quoted value of " + key
+	}
+	return key, val, ""
+}
+
+// unquote reads a double quoted value up to its closing quote, resolving escape sequences.
+// An escaped '$' before '{' is kept as literal "${", if vars.
+func unquote(s string, vars bool) (val, rest string, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			return b.String(), s[i+1:], true
+		}
+		if c != '\\' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '$':
+			if vars && i+1 < len(s) && s[i+1] == '{' {
+				b.WriteByte('$')
+			}
+			b.WriteByte('$')
+		default: // \" and \\ and unknown ones
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
+
+// validKey tells whether key is a valid config key
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, c := range key {
+		switch {
+		case c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+		case i > 0 && ((c >= '0' && c <= '9') || c == '.' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package crconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ProfileKey is the key selecting the profile on Read.
+// It can be given as switch --profile, environment variable or in the common part of the config.
+var ProfileKey = "APP_PROFILE"
+
+// CommonProfile is the section or file name holding the values common to all profiles
+const CommonProfile = "common"
+
+var profile string
+
+// Profile returns the profile selected on Read, empty if none.
+//
+// A config file can have sections like [dev] or [prod] for each profile.
+// Values before the first section and in section [common] are used by all profiles,
+// values of the selected profile override them.
+// Read can also be given a directory, containing common.env and a file per profile like prod.env.
+func Profile() string {
+	return profile
+}
+
+func profileSwitch() Switch {
+	return Switch{Long: "--profile", EnvKey: ProfileKey, Description: "config profile to use"}
+}
+
+// selectProfile selects the profile by switch, environment or common config
+func selectProfile(common map[string]string) string {
+	args, _, _, _ := parseArgs(os.Args, []Switch{profileSwitch()}, false)
+	if prof := args[ProfileKey]; prof != "" {
+		return prof
+	}
+	if prof := os.Getenv(ProfileKey); prof != "" {
+		return prof
+	}
+	return common[ProfileKey]
+}
+
+// readConfig reads the config file or directory at path, for the profile chosen by choose.
+// profiled tells whether path has profiles at all, being a directory or a file with sections.
+// choose is called only then.
+func readConfig(path string, choose func(common map[string]string) string) (values map[string]string, sws []Switch, prof string, profiled bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+	if info.IsDir() {
+		values, sws, prof, err = readDir(path, choose)
+		return values, sws, prof, true, err
+	}
+
+	sections, switches, err := parseFile(path)
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+
+	values = sections[""]
+	sws = switches[""]
+	if len(sections) == 1 {
+		return values, sws, "", false, nil // no sections at all
+	}
+	for key, val := range sections[CommonProfile] {
+		values[key] = val
+	}
+	sws = append(sws, switches[CommonProfile]...)
+
+	prof = choose(values)
+	if prof == "" || prof == CommonProfile {
+		return values, sws, prof, true, nil
+	}
+	if _, ok := sections[prof]; !ok {
+		return nil, nil, "", true, fmt.Errorf("%s: profile %s not found", path, prof)
+	}
+	for key, val := range sections[prof] {
+		values[key] = val
+	}
+	return values, append(sws, switches[prof]...), prof, true, nil
+}
+
+// readDir reads common.env and the profile file from dir
+func readDir(dir string, choose func(common map[string]string) string) (values map[string]string, sws []Switch, prof string, err error) {
+	values = map[string]string{}
+
+	common := filepath.Join(dir, CommonProfile+".env")
+	if _, err := os.Stat(common); err == nil {
+		if values, sws, _, _, err = readConfig(common, noProfile); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	prof = choose(values)
+	if prof == "" || prof == CommonProfile {
+		return values, sws, prof, nil
+	}
+
+	profValues, profSws, _, _, err := readConfig(filepath.Join(dir, prof+".env"), noProfile)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("profile %s: %s", prof, err.Error())
+	}
+	for key, val := range profValues {
+		values[key] = val
+	}
+	return values, append(sws, profSws...), prof, nil
+}
+
+func noProfile(map[string]string) string {
+	return ""
+}
@@ -0,0 +1,283 @@
+package crconfig
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote reads config values from a Consul style key/value HTTP API.
+// Use it by UseRemote(), its values are then used below environment variables and above the config file.
+// If the service is unreachable, the last known values are kept.
+type Remote struct {
+	// Address of the service, e.g. "http://consul:8500"
+	Address string
+	// Prefix of the keys, e.g. "config/myapp/". It is removed from the keys.
+	Prefix string
+	// Token is sent as X-Consul-Token, if set
+	Token string
+	// Wait is the maximum time a blocking query waits for changes
+	Wait time.Duration
+	// CacheFile is optional. Values are stored there, to be used if the service is unreachable on start.
+	CacheFile string
+	// Client is the http client used for requests
+	Client *http.Client
+
+	mutex  sync.RWMutex
+	values map[string]string
+	index  uint64
+	cancel context.CancelFunc
+}
+
+type kvPair struct {
+	Key         string
+	Value       *string
+	ModifyIndex uint64
+}
+
+var (
+	remoteMutex sync.RWMutex
+	remote      *Remote
+)
+
+// NewRemote returns a Remote for the service at address, reading all keys starting with prefix
+func NewRemote(address, prefix string) *Remote {
+	return &Remote{
+		Address: strings.TrimRight(address, "/"),
+		Prefix:  prefix,
+		Wait:    5 * time.Minute,
+		Client:  &http.Client{},
+	}
+}
+
+// UseRemote sets r as the remote layer. Use nil to remove it.
+// It loads the values of r, the error tells if that failed.
+func UseRemote(r *Remote) error {
+	remoteMutex.Lock()
+	remote = r
+	remoteMutex.Unlock()
+	if r == nil {
+		return nil
+	}
+	return r.Load()
+}
+
+// currentRemote returns the remote layer set by UseRemote, nil if there is none
+func currentRemote() *Remote {
+	remoteMutex.RLock()
+	defer remoteMutex.RUnlock()
+	return remote
+}
+
+// Load loads all values from the service.
+// On errors the last known values are kept, or the values of CacheFile, if there are none yet.
+// An error writing CacheFile is returned as well, the values are used anyway.
+func (r *Remote) Load() error {
+	_, err := r.fetch(context.Background(), 0)
+	if err != nil {
+		r.mutex.Lock()
+		if r.values == nil && r.CacheFile != "" {
+			r.values, _ = r.readCache()
+		}
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+// Start starts watching the service for changes by blocking queries.
+// On changes all funcs registered by OnReload are called.
+func (r *Remote) Start() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	go func() {
+		backoff := time.Second
+		for {
+			r.mutex.RLock()
+			index := r.index
+			r.mutex.RUnlock()
+
+			changed, err := r.fetch(ctx, index)
+			if changed {
+				reloaded() // even if writing the cache failed
+			}
+			wait := time.Duration(0)
+			switch {
+			case err != nil:
+				wait = backoff
+				if backoff < time.Minute {
+					backoff *= 2
+				}
+			case changed:
+				backoff = time.Second
+			default:
+				// nothing changed within Wait, don't hammer services not supporting blocking queries
+				backoff = time.Second
+				wait = time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop stops watching the service
+func (r *Remote) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+// Get gets the value of key, as last loaded
+func (r *Remote) Get(key string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	val, ok := r.values[key]
+	return val, ok
+}
+
+// keys returns all keys, as last loaded
+func (r *Remote) keys() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	res := make([]string, 0, len(r.values))
+	for key := range r.values {
+		res = append(res, key)
+	}
+	return res
+}
+
+// fetch loads all values, blocking until the index changes if index > 0.
+// changed tells whether the values changed.
+func (r *Remote) fetch(ctx context.Context, index uint64) (changed bool, err error) {
+	query := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(r.Wait.Seconds())))
+	}
+	req, err := http.NewRequest(http.MethodGet, r.Address+"/v1/kv/"+r.Prefix+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	if r.Token != "" {
+		req.Header.Set("X-Consul-Token", r.Token)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	var pairs []kvPair
+	switch res.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(res.Body).Decode(&pairs); err != nil {
+			return false, err
+		}
+	case http.StatusNotFound: // no keys at all
+	default:
+		body, _ := ioutil.ReadAll(res.Body)
+		return false, fmt.Errorf("remote config: %s %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	values := map[string]string{}
+	for _, p := range pairs {
+		key := strings.TrimPrefix(p.Key, r.Prefix)
+		if key == "" || p.Value == nil {
+			continue // folders
+		}
+		val, err := base64.StdEncoding.DecodeString(*p.Value)
+		if err != nil {
+			return false, fmt.Errorf("remote config: %s: %s", key, err.Error())
+		}
+		values[key] = string(val)
+	}
+
+	newIndex, _ := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+
+	r.mutex.Lock()
+	if newIndex < r.index {
+		newIndex = 0 // index went backwards, start over
+	}
+	r.index = newIndex
+	changed = !equalMaps(r.values, values)
+	r.values = values
+	r.mutex.Unlock()
+
+	if changed && r.CacheFile != "" {
+		if err := r.writeCache(values); err != nil {
+			return true, fmt.Errorf("remote config: writing cache: %s", err.Error())
+		}
+	}
+	return changed, nil
+}
+
+// writeCache writes the values double quoted, so readCache reads them unchanged
+func (r *Remote) writeCache(values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key + "=\"" + esc.Replace(values[key]) + "\"\n")
+	}
+	return ioutil.WriteFile(r.CacheFile, []byte(b.String()), 0600)
+}
+
+// readCache reads the values written by writeCache, without interpolating them
+func (r *Remote) readCache() (map[string]string, error) {
+	f, err := os.Open(r.CacheFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values, _, err := parse(f, r.CacheFile, false)
+	if err != nil {
+		return nil, err
+	}
+	return values[""], nil
+}
+
+func equalMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, val := range a {
+		if v, ok := b[key]; !ok || v != val {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package crconfig_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+// kvServer is a minimal Consul style key/value service
+type kvServer struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	index   uint64
+	changed chan struct{}
+}
+
+func (s *kvServer) set(key, val string) {
+	s.mutex.Lock()
+	s.values[key] = val
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mutex.Unlock()
+}
+
+func (s *kvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+	s.mutex.Lock()
+	changed := s.changed
+	index := s.index
+	s.mutex.Unlock()
+
+	if idx, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); idx > 0 && idx == index {
+		select {
+		case <-changed:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	type pair struct {
+		Key   string
+		Value string
+	}
+	pairs := []pair{{Key: prefix}}
+	for key, val := range s.values {
+		pairs = append(pairs, pair{Key: prefix + key, Value: base64.StdEncoding.EncodeToString([]byte(val))})
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func TestRemote(t *testing.T) {
+	test := assert.New(t)
+
+	kv := &kvServer{
+		values:  map[string]string{"FIRST_VAL": "remote first", "ANOTHER_THING": "remote another", "REMOTE_ONLY": "only here"},
+		index:   1,
+		changed: make(chan struct{}),
+	}
+	srv := httptest.NewServer(kv)
+
+	os.Args = []string{"test_cmd"}
+	err := crconfig.Read("testdata.env")
+	test.Nil(err)
+
+	cache, err := ioutil.TempFile("", "crconfig")
+	test.Nil(err)
+	cache.Close()
+	defer os.Remove(cache.Name())
+
+	r := crconfig.NewRemote(srv.URL, "config/app/")
+	r.CacheFile = cache.Name()
+	test.Nil(crconfig.UseRemote(r))
+	defer crconfig.UseRemote(nil)
+
+	os.Setenv("ANOTHER_THING", "from env")
+	defer os.Unsetenv("ANOTHER_THING")
+
+	test.Equal("remote first", crconfig.Get("FIRST_VAL", ""))
+	test.Equal("from env", crconfig.Get("ANOTHER_THING", ""))
+	test.Equal("only here", crconfig.Get("REMOTE_ONLY", ""))
+	test.EqualValues(42, crconfig.GetInt("MY_NUMVBER", 0))
+
+	reloads := make(chan struct{}, 1)
+	crconfig.OnReload(func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	})
+
+	r.Start()
+	kv.set("REMOTE_ONLY", "changed")
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		test.Fail("no reload")
+	}
+	test.Equal("changed", crconfig.Get("REMOTE_ONLY", ""))
+	r.Stop()
+
+	// service gone, last values stay
+	srv.Close()
+	test.NotNil(r.Load())
+	test.Equal("changed", crconfig.Get("REMOTE_ONLY", ""))
+
+	// service gone on start, values from cache
+	r2 := crconfig.NewRemote(srv.URL, "config/app/")
+	r2.CacheFile = cache.Name()
+	test.NotNil(crconfig.UseRemote(r2))
+	test.Equal("changed", crconfig.Get("REMOTE_ONLY", ""))
+	test.Equal("remote first", crconfig.Get("FIRST_VAL", ""))
+}
+
+func TestRemoteCache(t *testing.T) {
+	test := assert.New(t)
+
+	odd := "a #b ${c} 'd' \"e\" \\f\n\tg"
+	kv := &kvServer{
+		values:  map[string]string{"ODD": odd, "PLAIN": "plain"},
+		index:   1,
+		changed: make(chan struct{}),
+	}
+	srv := httptest.NewServer(kv)
+
+	cache, err := ioutil.TempFile("", "crconfig")
+	test.Nil(err)
+	cache.Close()
+	defer os.Remove(cache.Name())
+
+	r := crconfig.NewRemote(srv.URL, "config/app/")
+	r.CacheFile = cache.Name()
+	test.Nil(r.Load())
+	srv.Close()
+
+	cached := crconfig.NewRemote(srv.URL, "config/app/")
+	cached.CacheFile = cache.Name()
+	test.NotNil(cached.Load())
+	val, _ := cached.Get("ODD")
+	test.Equal(odd, val)
+	val, _ = cached.Get("PLAIN")
+	test.Equal("plain", val)
+
+	// the error writing the cache is returned, the values are used anyway
+	srv = httptest.NewServer(kv)
+	defer srv.Close()
+	r = crconfig.NewRemote(srv.URL, "config/app/")
+	r.CacheFile = os.TempDir() // a directory
+	test.NotNil(r.Load())
+	val, _ = r.Get("PLAIN")
+	test.Equal("plain", val)
+}
+
+func TestRemoteRace(t *testing.T) {
+	test := assert.New(t)
+
+	kv := &kvServer{
+		values:  map[string]string{"RACE_VAL": "0"},
+		index:   1,
+		changed: make(chan struct{}),
+	}
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+
+	r := crconfig.NewRemote(srv.URL, "config/app/")
+	test.Nil(crconfig.UseRemote(r))
+	defer crconfig.UseRemote(nil)
+	r.Start()
+	defer r.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 20; i++ {
+			kv.set("RACE_VAL", strconv.Itoa(i))
+			crconfig.UseRemote(r)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			crconfig.Get("RACE_VAL", "")
+			crconfig.Entries()
+		}
+	}
+	test.Eventually(func() bool {
+		return crconfig.Get("RACE_VAL", "") == "20"
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,203 @@
+package crconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Field describes one config key of a struct used with Bind.
+// It is read from the tags `env:"KEY,default"`, `desc:"description"`, `required:"true"` and `secret:"true"`.
+type Field struct {
+	Key         string
+	Type        string
+	Default     string
+	Description string
+	Required    bool
+	Secret      bool
+
+	goType reflect.Type
+}
+
+// Describe returns the fields of obj, as bound by Bind.
+func Describe(obj interface{}) ([]Field, error) {
+	return describe(obj, false)
+}
+
+// DescribeExclusive returns the fields of obj, as bound by BindExclusive.
+func DescribeExclusive(obj interface{}) ([]Field, error) {
+	return describe(obj, true)
+}
+
+func describe(obj interface{}, exclusive bool) ([]Field, error) {
+	t := reflect.TypeOf(obj)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can not describe given obj")
+	}
+
+	var res []Field
+	_, err := walk(reflect.New(t).Elem(), "", exclusive, func(f Field, _ reflect.Value) (bool, error) {
+		res = append(res, f)
+		return false, nil
+	})
+	return res, err
+}
+
+// typeName returns a readable name of t
+func typeName(t reflect.Type) string {
+	switch {
+	case reflect.PtrTo(t).Implements(textUnmarshalerType):
+		return t.String()
+	case t == durationType:
+		return "duration"
+	case t == urlType:
+		return "url"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeName(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return "list of " + typeName(t.Elem())
+	case reflect.Map:
+		return "map of " + typeName(t.Key()) + " to " + typeName(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+// Markdown returns the fields as Markdown table
+func Markdown(fields []Field) string {
+	var b strings.Builder
+	b.WriteString("| Key | Type | Default | Required | Description |\n")
+	b.WriteString("|-----|------|---------|----------|-------------|\n")
+	for _, f := range fields {
+		def, req, desc := "", "", strings.ReplaceAll(f.Description, "|", "\\|")
+		if f.Default != "" {
+			def = "`" + f.Default + "`"
+		}
+		if f.Required {
+			req = "yes"
+		}
+		if f.Secret {
+			desc = strings.TrimSpace(desc + " *(secret)*")
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n", f.Key, f.Type, def, req, desc)
+	}
+	return b.String()
+}
+
+// SampleEnv returns the fields as sample config file, with the defaults as values.
+// Secrets are left empty.
+func SampleEnv(fields []Field) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		info := []string{f.Type}
+		if f.Required {
+			info = append(info, "required")
+		}
+		if f.Secret {
+			info = append(info, "secret")
+		}
+		desc := strings.TrimSpace(f.Description + " (" + strings.Join(info, ", ") + ")")
+		fmt.Fprintf(&b, "# %s\n", desc)
+
+		def := f.Default
+		if f.Secret {
+			def = ""
+		}
+		fmt.Fprintf(&b, "%s=%s\n", f.Key, def)
+	}
+	return b.String()
+}
+
+// JSONSchema returns the fields as JSON Schema of an object having the keys as properties
+func JSONSchema(fields []Field) ([]byte, error) {
+	props := map[string]interface{}{}
+	required := []string{}
+	for _, f := range fields {
+		p := jsonType(f.goType)
+		if f.Description != "" {
+			p["description"] = f.Description
+		}
+		if f.Default != "" && !f.Secret {
+			p["default"] = jsonValue(f.goType, f.Default)
+		}
+		if f.Secret {
+			p["writeOnly"] = true
+		}
+		props[f.Key] = p
+		if f.Required {
+			required = append(required, f.Key)
+		}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":    "http://json-schema.org/draft-07/schema#",
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}, "", "  ")
+}
+
+// jsonType returns the JSON Schema type of t
+func jsonType(t reflect.Type) map[string]interface{} {
+	if t == nil || reflect.PtrTo(t).Implements(textUnmarshalerType) || t == durationType || t == urlType {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		return map[string]interface{}{"type": "array", "items": jsonType(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonType(t.Elem())}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// jsonValue converts the config value val into the JSON type matching t
+func jsonValue(t reflect.Type, val string) interface{} {
+	switch jsonType(t)["type"] {
+	case "boolean":
+		b, _ := parseBool(val)
+		return b
+	case "integer":
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(val, 64); err == nil {
+			return n
+		}
+	case "array":
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		res := []interface{}{}
+		for _, part := range strings.Split(val, ",") {
+			res = append(res, jsonValue(t.Elem(), strings.TrimSpace(part)))
+		}
+		return res
+	}
+	return val
+}
@@ -0,0 +1,248 @@
+package crconfig
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Redacted is shown instead of the value of secret keys
+const Redacted = "******"
+
+// secretPrefix starts values referencing a SecretProvider,
+// so plain values like "file:x" keep their meaning
+const secretPrefix = "secret:"
+
+type (
+	// SecretProvider resolves secret references.
+	// A value like "secret:vault:db_pass" is resolved by calling Secret("db_pass")
+	// on the provider registered for "vault".
+	SecretProvider interface {
+		Secret(ref string) (string, error)
+	}
+
+	// SecretProviderFunc makes a simple func a SecretProvider
+	SecretProviderFunc func(ref string) (string, error)
+
+	// Vault is a SecretProvider reading secrets from an encrypted file.
+	// The file contains a config file (KEY=value per line), sealed by SealVault.
+	Vault struct {
+		values map[string]string
+	}
+)
+
+var (
+	secretMutex sync.RWMutex
+	secretKeys  = map[string]bool{}
+	providers   = map[string]SecretProvider{
+		"file": SecretProviderFunc(fileSecret),
+		"env":  SecretProviderFunc(envSecret),
+	}
+)
+
+// Secret calls f(ref)
+func (f SecretProviderFunc) Secret(ref string) (string, error) {
+	return f(ref)
+}
+
+// RegisterProvider registers a SecretProvider for values starting with "secret:", scheme and a colon.
+// "file" and "env" are registered by default.
+func RegisterProvider(scheme string, p SecretProvider) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	providers[scheme] = p
+}
+
+// MarkSecret marks keys as secret, so their values never show up in dumps, debug output or errors.
+// Keys referencing a SecretProvider are secret anyway.
+func MarkSecret(keys ...string) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	for _, key := range keys {
+		secretKeys[key] = true
+	}
+}
+
+// IsSecret tells whether the value of key is secret.
+// Values composed of secrets by ${KEY} are secret as well.
+func IsSecret(key string) bool {
+	return isSecret(key, rawLookup, map[string]bool{})
+}
+
+// isSecret tells whether key is marked, its value references a secret provider or any key it references is secret.
+// lookup gets the raw values, seen holds the keys checked already, to stop on cycles.
+func isSecret(key string, lookup func(string) (string, bool), seen map[string]bool) bool {
+	if seen[key] {
+		return false
+	}
+	seen[key] = true
+
+	secretMutex.RLock()
+	marked := secretKeys[key]
+	secretMutex.RUnlock()
+	if marked {
+		return true
+	}
+	val, _ := lookup(key)
+	if _, _, ok := provider(val); ok {
+		return true
+	}
+	for _, ref := range variables(val) {
+		if isSecret(ref, lookup, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns val, or Redacted if key is secret
+func Redact(key, val string) string {
+	if IsSecret(key) {
+		return Redacted
+	}
+	return val
+}
+
+// redactErr removes val from the message of err, if key is secret
+func redactErr(key, val string, err error) error {
+	msg := err.Error()
+	if val != "" && IsSecret(key) {
+		msg = strings.ReplaceAll(msg, val, Redacted)
+	}
+	return fmt.Errorf("%s: %s", key, msg)
+}
+
+// provider finds the SecretProvider referenced by val
+func provider(val string) (p SecretProvider, ref string, ok bool) {
+	if !strings.HasPrefix(val, secretPrefix) {
+		return nil, "", false
+	}
+	val = val[len(secretPrefix):]
+	pos := strings.Index(val, ":")
+	if pos <= 0 {
+		return nil, "", false
+	}
+	secretMutex.RLock()
+	p, ok = providers[val[:pos]]
+	secretMutex.RUnlock()
+	return p, val[pos+1:], ok
+}
+
+// resolve resolves val, if it references a SecretProvider
+func resolve(key, val string) (string, error) {
+	p, ref, ok := provider(val)
+	if !ok {
+		return val, nil
+	}
+	res, err := p.Secret(ref)
+	if err != nil {
+		return "", fmt.Errorf("%s: resolving secret failed: %s", key, err.Error())
+	}
+	return res, nil
+}
+
+func fileSecret(ref string) (string, error) {
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func envSecret(ref string) (string, error) {
+	if val := os.Getenv(ref); val != "" {
+		return val, nil
+	}
+	return "", fmt.Errorf("environment variable %s not set", ref)
+}
+
+// OpenVault reads an encrypted file created by SealVault.
+// keyEnv is the name of the environment variable holding the base64 encoded AES key (16, 24 or 32 bytes).
+// Register it to use it, e.g. RegisterProvider("vault", v) for values like "secret:vault:DB_PASS".
+func OpenVault(file, keyEnv string) (*Vault, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv(keyEnv))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("no valid vault key in %s", keyEnv)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openVault(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err.Error())
+	}
+
+	// raw KEY=value lines, so secrets are taken as they are, without quoting, comments or variables
+	v := &Vault{values: map[string]string{}}
+	scan := bufio.NewScanner(bytes.NewReader(plain))
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if parts := strings.SplitN(line, "=", 2); len(parts) > 1 {
+			v.values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return v, scan.Err()
+}
+
+// Secret returns the secret named ref
+func (v *Vault) Secret(ref string) (string, error) {
+	if val, ok := v.values[ref]; ok {
+		return val, nil
+	}
+	return "", fmt.Errorf("%s not found in vault", ref)
+}
+
+// SealVault encrypts plain with AES-GCM using key, to be read by OpenVault.
+// The result is base64 encoded, so it can be stored as text.
+func SealVault(plain, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+
+	res := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(res, sealed)
+	return res, nil
+}
+
+func openVault(data, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("vault too short")
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,7 +1,14 @@
 package crconfig
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type (
@@ -9,31 +16,218 @@ type (
 	Switch struct {
 		// The command line switch. Usually starts with a '-'.
 		Switch string
+		// Optional long form of the switch, e.g. "--number".
+		Long string
 		// The corresponding environment variable this value overrides.
 		EnvKey string
 		// Description to be shown for this switch when calling -h or --help
 		Description string
+		// Bool switches take no value, they set "true" if given.
+		Bool bool
 	}
 )
 
+var (
+	// ErrHelp is returned by SetSwitches, if -h or --help was given.
+	ErrHelp = errors.New("help requested")
+
+	// HelpOutput is where the help text is written to on -h or --help
+	HelpOutput io.Writer = os.Stdout
+
+	switches     []Switch // defined by SetSwitches
+	fileSwitches []Switch // defined in config file
+	positional   []string
+)
+
 // SetSwitches defines one or more cli switches.
 // Use this if you plan a sophisticated command line application.
-func SetSwitches(switches ...Switch) {
-	args := map[string]string{}
-	for _, sw := range switches {
-		args[sw.Switch] = sw.EnvKey
+// Supported are "-n value", "-n=value", "--long value", "--long=value",
+// bool switches without a value, grouped short bool switches like "-abc" and "--" to stop parsing switches.
+// Unknown switches and missing values are reported as error.
+// On -h or --help the help text is written to HelpOutput and ErrHelp is returned.
+func SetSwitches(sws ...Switch) error {
+	switches = append(switches, sws...)
+
+	values, args, help, err := parseArgs(os.Args, allSwitches(), true)
+	if err != nil {
+		return err
+	}
+	if help {
+		fmt.Fprint(HelpOutput, Usage())
+		return ErrHelp
+	}
+	if cli == nil {
+		cli = map[string]string{}
+	}
+	for key, val := range values {
+		cli[key] = val
+	}
+	positional = args
+	return nil
+}
+
+// Args returns the command line arguments not being switches, after SetSwitches was called.
+// The first one is usually the program itself.
+func Args() []string {
+	return positional
+}
+
+// Usage returns the help text generated from all defined switches
+func Usage() string {
+	sws := allSwitches()
+	sort.SliceStable(sws, func(i, j int) bool {
+		return strings.TrimLeft(sws[i].name(), "-") < strings.TrimLeft(sws[j].name(), "-")
+	})
+	sws = append(sws, Switch{Switch: "-h", Long: "--help", Description: "show this help", Bool: true})
+
+	names := make([]string, len(sws))
+	width := 0
+	for i, sw := range sws {
+		var forms []string
+		for _, f := range []string{sw.Switch, sw.Long} {
+			if f != "" {
+				forms = append(forms, f)
+			}
+		}
+		names[i] = strings.Join(forms, ", ")
+		if !sw.Bool {
+			names[i] += " <value>"
+		}
+		if len(names[i]) > width {
+			width = len(names[i])
+		}
+	}
+
+	prog := "app"
+	if len(os.Args) > 0 {
+		prog = filepath.Base(os.Args[0])
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Usage: %s [switches]\n\n", prog)
+	for i, sw := range sws {
+		desc := sw.Description
+		if sw.EnvKey != "" {
+			desc = strings.TrimSpace(desc + " (" + sw.EnvKey + ")")
+		}
+		fmt.Fprintf(&b, "  %-*s  %s\n", width, names[i], desc)
+	}
+	return b.String()
+}
+
+func (sw Switch) name() string {
+	if sw.Switch != "" {
+		return sw.Switch
+	}
+	return sw.Long
+}
+
+func allSwitches() []Switch {
+	res := make([]Switch, 0, len(fileSwitches)+len(switches))
+	res = append(res, fileSwitches...)
+	return append(res, switches...)
+}
+
+func findSwitch(sws []Switch, name string) *Switch {
+	for i := len(sws) - 1; i >= 0; i-- { // later definitions win
+		if sws[i].Switch == name || sws[i].Long == name {
+			return &sws[i]
+		}
+	}
+	return nil
+}
+
+// parseArgs parses args for sws and returns the values by env key and the positional arguments.
+// If strict, unknown switches and missing values are errors, otherwise they are ignored.
+func parseArgs(args []string, sws []Switch, strict bool) (values map[string]string, rest []string, help bool, err error) {
+	values = map[string]string{}
+
+	fail := func(format string, a ...interface{}) error {
+		if strict {
+			return fmt.Errorf(format, a...)
+		}
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' || (isNegative(arg) && findSwitch(sws, arg) == nil) {
+			rest = append(rest, arg)
+			continue
+		}
+
+		name, val, hasVal := arg, "", false
+		if pos := strings.Index(arg, "="); pos > 0 {
+			name, val, hasVal = arg[:pos], arg[pos+1:], true
+		}
+
+		sw := findSwitch(sws, name)
+		if sw == nil && (name == "-h" || name == "--help") && strict {
+			help = true
+			continue
+		}
+		if sw == nil && !hasVal && !strings.HasPrefix(arg, "--") {
+			if group, ok := parseGroup(arg, sws); ok {
+				for _, key := range group {
+					values[key] = "true"
+				}
+				continue
+			}
+		}
+		if sw == nil {
+			if err = fail("unknown switch %s", name); err != nil {
+				return nil, nil, false, err
+			}
+			continue
+		}
+
+		switch {
+		case sw.Bool && hasVal:
+			if _, e := strconv.ParseBool(val); e != nil {
+				if err = fail("invalid value for %s: %s", name, val); err != nil {
+					return nil, nil, false, err
+				}
+				continue
+			}
+		case sw.Bool:
+			val = "true"
+		case !hasVal && i+1 < len(args):
+			i++
+			val = args[i]
+		case !hasVal:
+			if err = fail("missing value for %s", name); err != nil {
+				return nil, nil, false, err
+			}
+			continue
+		}
+		values[sw.EnvKey] = val
+	}
+
+	return values, rest, help, nil
+}
+
+// isNegative tells whether arg is a negative number like "-5" or "-1.5", to be taken as value, not as switch
+func isNegative(arg string) bool {
+	if arg[0] != '-' || !(arg[1] >= '0' && arg[1] <= '9' || arg[1] == '.') {
+		return false // no -Inf or -NaN
 	}
-	parseSwitches(args)
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
 }
 
-// parses args, where args is a mapping switch -> env key
-func parseSwitches(args map[string]string) {
-	key, ok := "", false
-	for _, arg := range os.Args {
-		if ok {
-			cli[key], ok = arg, false
-		} else {
-			key, ok = args[arg]
+// parseGroup parses grouped short bool switches like "-abc" into their env keys
+func parseGroup(arg string, sws []Switch) ([]string, bool) {
+	var keys []string
+	for _, c := range arg[1:] {
+		sw := findSwitch(sws, "-"+string(c))
+		if sw == nil || !sw.Bool {
+			return nil, false
 		}
+		keys = append(keys, sw.EnvKey)
 	}
+	return keys, true
 }
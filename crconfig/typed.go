@@ -0,0 +1,50 @@
+package crconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// As gets the value of key as type T, according to the given key.
+// if key is not found or can not be parsed, def is used.
+// Supported are all types Bind supports, e.g. ints, uints, floats, bools, time.Duration,
+// time.Time (RFC 3339), *url.URL and net.IP.
+//
+//	timeout := crconfig.As("TIMEOUT", 5*time.Second)
+//	endpoint := crconfig.As[*url.URL]("ENDPOINT", nil)
+func As[T any](key string, def T) T {
+	if val, ok, err := LookupAs[T](key); ok && err == nil {
+		return val
+	}
+	return def
+}
+
+// LookupAs gets the value of key as type T, see As.
+// found tells if the key exists at all, err if its value could not be parsed.
+func LookupAs[T any](key string) (val T, found bool, err error) {
+	raw, found, err := Lookup(key)
+	if !found || err != nil {
+		return val, found, err
+	}
+
+	v := reflect.ValueOf(&val).Elem()
+	if !isSupported(v.Type()) {
+		return val, true, fmt.Errorf("%s: unsupported type %s", key, v.Type())
+	}
+	if err := setValue(v, raw); err != nil {
+		return val, true, redactErr(key, raw, err)
+	}
+	return val, true, nil
+}
+
+// parseBool parses true, 1, yes, on and false, 0, no, off, ignoring case
+func parseBool(val string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "true", "1", "yes", "on":
+		return true, nil
+	case "false", "0", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid bool %q", val)
+}
@@ -0,0 +1,61 @@
+package crconfig
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseDuration parses a Go duration string like "1500ms" or "2m".
+// Bare integers are taken as multiples of unit, for backward compatibility.
+func ParseDuration(val string, unit time.Duration) (time.Duration, error) {
+	val = strings.TrimSpace(val)
+	if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(val)
+}
+
+// ParseBytes parses a size like "512", "10MB" or "512KiB" into bytes.
+// KB, MB, GB, TB and PB are decimal (1000), KiB, MiB, GiB, TiB and PiB binary (1024) units.
+// Units are case insensitive, fractions like "1.5GB" are allowed.
+func ParseBytes(val string) (int64, error) {
+	val = strings.TrimSpace(val)
+	pos := strings.IndexFunc(val, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := val, ""
+	if pos >= 0 {
+		num, unit = val[:pos], strings.TrimSpace(val[pos:])
+	}
+
+	mult, ok := byteUnits[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q in %q", unit, val)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", val)
+	}
+	if n*mult > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q out of range", val)
+	}
+	return int64(n * mult), nil
+}
@@ -0,0 +1,189 @@
+package crmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrInvalidToken is returned for resume tokens not made by the driver
+var ErrInvalidToken = errors.New("invalid resume token")
+
+// plainDoc deep copies a document of the driver into plain maps and slices for a Change
+func plainDoc(doc map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		return nil
+	}
+	res := make(map[string]interface{}, len(doc))
+	for key, val := range doc {
+		res[key] = plainValue(val)
+	}
+	return res
+}
+
+func plainValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case bson.M:
+		return plainDoc(val)
+	case map[string]interface{}:
+		return plainDoc(val)
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, sub := range val {
+			res[i] = plainValue(sub)
+		}
+		return res
+	}
+	return v
+}
+
+// SubscriptionsCollection keeps the resume tokens of subscriptions
+var SubscriptionsCollection = "subscriptions"
+
+// SubscribeOptions are optional for subscribing
+type SubscribeOptions struct {
+	// Capped tails the capped collection itself instead of the change feed of the database.
+	// Only inserts are delivered then.
+	Capped bool
+	// Buffer is the capacity of the channel
+	Buffer int
+}
+
+// Subscription delivers the changes of a collection to its channel C.
+// Changes are delivered at least once, so consumers should handle them idempotently.
+type Subscription struct {
+	// C delivers the changes, it is closed when the subscription ends
+	C <-chan Change
+
+	db     *DB
+	name   string
+	coll   string
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+	err    error
+}
+
+type subscriptionToken struct {
+	ID         string    `bson:"_id"`
+	Collection string    `bson:"collection"`
+	Token      string    `bson:"token"`
+	UpdatedAt  time.Time `bson:"updated_at"`
+}
+
+// Subscribe tails the changes of collection until ctx is done or the Subscription is closed.
+// The name identifies the consumer: a subscription continues after the last change acknowledged
+// by Ack under the same name, e.g. after a restart. A new one starts with the next change.
+// Network errors are retried with a backoff up to MONGO_RECONNECT_MAX_BACKOFF, but at least after 1s.
+func (d *DB) Subscribe(ctx context.Context, name, collection string, opts *SubscribeOptions) (*Subscription, error) {
+	driverFeed, ok := d.driver.(ChangeFeed)
+	if !ok {
+		return nil, fmt.Errorf("changes: %w", ErrUnsupported)
+	}
+	feed := &instrumentedFeed{ChangeFeed: driverFeed, db: d.dbName, threshold: d.slowQuery}
+	if opts == nil {
+		opts = &SubscribeOptions{}
+	}
+
+	var stored subscriptionToken
+	err := d.Collection(SubscriptionsCollection).FindOne(ctx, Q{"_id": name}, nil, &stored)
+	switch {
+	case err == ErrNotFound:
+		if stored.Token, err = feed.LastChange(ctx, collection, opts.Capped); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case stored.Collection != collection:
+		return nil, fmt.Errorf("subscription %s follows collection %s", name, stored.Collection)
+	}
+
+	ch := make(chan Change, opts.Buffer)
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{C: ch, db: d, name: name, coll: collection, cancel: cancel, done: make(chan struct{})}
+	go s.run(ctx, feed, stored.Token, opts.Capped, ch)
+	return s, nil
+}
+
+// Unsubscribe deletes the resume token of the subscription called name,
+// so the next one starts with the next change
+func (d *DB) Unsubscribe(ctx context.Context, name string) error {
+	_, err := d.Collection(SubscriptionsCollection).Delete(ctx, Q{"_id": name}, false)
+	return err
+}
+
+// run relays the changes of the feed to ch and restarts it after failures
+func (s *Subscription) run(ctx context.Context, feed ChangeFeed, token string, capped bool, ch chan<- Change) {
+	defer close(s.done)
+	defer close(ch)
+
+	for failures := 0; ; {
+		in := make(chan Change)
+		errc := make(chan error, 1)
+		go func(token string) { errc <- feed.Changes(ctx, s.coll, token, capped, in) }(token)
+
+		var err error
+	relay:
+		for {
+			select {
+			case c := <-in:
+				select {
+				case ch <- c:
+					token = c.Token
+					failures = 0
+				case <-ctx.Done():
+				}
+			case err = <-errc:
+				break relay
+			}
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, ErrUnsupported) || errors.Is(err, ErrInvalidToken):
+			s.setErr(err)
+			return
+		}
+
+		failures++
+		wait := backoff(failures, s.db.health.maxBackoff)
+		Logger.Debugln("subscription", s.name, "failed, retrying in", wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Subscription) setErr(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+// Ack stores the token of the change, so the subscription continues after it when subscribed again
+func (s *Subscription) Ack(ctx context.Context, c Change) error {
+	_, err := s.db.Collection(SubscriptionsCollection).Update(ctx, Q{"_id": s.name},
+		U{}.Set("collection", s.coll).Set("token", c.Token).Set("updated_at", time.Now()),
+		&UpdateOptions{Upsert: true})
+	return err
+}
+
+// Err returns the error, which ended the subscription, after C is closed
+func (s *Subscription) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Close ends the subscription and waits until C is closed
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
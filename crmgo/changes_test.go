@@ -0,0 +1,211 @@
+package crmgo_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func receive(t *testing.T, sub *crmgo.Subscription) crmgo.Change {
+	select {
+	case c := <-sub.C:
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change received")
+	}
+	return crmgo.Change{}
+}
+
+func TestSubscribe(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	db := openMemory(t)
+	family := db.Collection("family")
+	insertFamily(t, family) // before subscribing, not delivered
+
+	sub, err := db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	test.Nil(family.Insert(ctx, &member{ID: "bob", Name: "Bob", Age: 70}))
+	test.Nil(db.Collection("other").Insert(ctx, &member{ID: "eve"}))
+	_, err = family.Update(ctx, crmgo.Q{"_id": "tim"}, crmgo.U{}.Inc("age", 1), nil)
+	test.Nil(err)
+	_, err = family.Delete(ctx, crmgo.Q{"_id": "lisa"}, false)
+	test.Nil(err)
+
+	c := receive(t, sub)
+	test.Equal("insert", c.Op)
+	test.Equal("family", c.Collection)
+	test.Equal("bob", c.ID)
+	test.Equal("Bob", c.Doc["name"])
+
+	c = receive(t, sub)
+	test.Equal("update", c.Op)
+	test.Equal("tim", c.ID)
+	test.Equal(map[string]interface{}{"age": 1}, c.Update["$inc"])
+	test.Nil(sub.Ack(ctx, c))
+
+	c = receive(t, sub)
+	test.Equal("delete", c.Op)
+	test.Equal("lisa", c.ID)
+
+	sub.Close()
+	_, open := <-sub.C
+	test.False(open)
+	test.Nil(sub.Err())
+
+	// continues after the acknowledged change
+	sub, err = db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	defer sub.Close()
+	c = receive(t, sub)
+	test.Equal("delete", c.Op)
+	test.Equal("lisa", c.ID)
+
+	_, err = db.Subscribe(ctx, "consumer", "other", nil)
+	test.NotNil(err)
+}
+
+func TestSubscribeCapped(t *testing.T) {
+	test := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := openMemory(t)
+	events := db.Collection("events")
+	sub, err := db.Subscribe(ctx, "consumer", "events", &crmgo.SubscribeOptions{Capped: true, Buffer: 10})
+	if !test.Nil(err) {
+		return
+	}
+	test.Nil(events.Insert(ctx, &member{ID: "1"}))
+	_, err = events.Delete(ctx, crmgo.Q{"_id": "1"}, false)
+	test.Nil(err)
+	test.Nil(events.Insert(ctx, &member{ID: "2"}))
+
+	test.Equal("1", receive(t, sub).ID)
+	test.Equal("2", receive(t, sub).ID) // inserts only
+
+	cancel()
+	for range sub.C {
+	}
+	test.Nil(sub.Err())
+}
+
+func TestSubscribeInvalidToken(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	db := openMemory(t)
+	err := db.Collection(crmgo.SubscriptionsCollection).Insert(ctx, crmgo.Q{"_id": "consumer", "collection": "family", "token": "x"})
+	test.Nil(err)
+
+	sub, err := db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	for range sub.C {
+	}
+	test.True(errors.Is(sub.Err(), crmgo.ErrInvalidToken))
+
+	test.Nil(db.Unsubscribe(ctx, "consumer"))
+	sub, err = db.Subscribe(ctx, "consumer", "family", nil)
+	test.Nil(err)
+	sub.Close()
+}
+
+func TestSubscribeLostToken(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	defer func(n int) { *crmgo.MemoryChangesKept = n }(*crmgo.MemoryChangesKept)
+	*crmgo.MemoryChangesKept = 3
+
+	db := openMemory(t)
+	family := db.Collection("family")
+	sub, err := db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	test.Nil(family.Insert(ctx, &member{ID: "bob"}))
+	test.Nil(sub.Ack(ctx, receive(t, sub)))
+	sub.Close()
+
+	// the change after the token is not kept anymore
+	insertFamily(t, family)
+	sub, err = db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	for range sub.C {
+	}
+	test.True(errors.Is(sub.Err(), crmgo.ErrInvalidToken))
+}
+
+// brokenFeed keeps its subscriptions in memory, but its feed always fails by network errors
+type brokenFeed struct {
+	flakyDriver
+	mem   *crmgo.DB
+	calls int32
+}
+
+func (d *brokenFeed) Collection(name string) crmgo.Collection { return d.mem.Collection(name) }
+func (d *brokenFeed) LastChange(ctx context.Context, collection string, capped bool) (string, error) {
+	return "", nil
+}
+func (d *brokenFeed) Changes(ctx context.Context, collection, token string, capped bool, ch chan<- crmgo.Change) error {
+	atomic.AddInt32(&d.calls, 1)
+	return io.EOF
+}
+
+func TestSubscribeMinBackoff(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	drv := &brokenFeed{mem: openMemory(t)}
+	crmgo.RegisterDriver("brokenfeed", func(ctx context.Context, suffix, dbname string) (crmgo.Driver, error) { return drv, nil })
+	t.Setenv("MONGO_DRIVER", "brokenfeed")
+	t.Setenv("MONGO_HEALTH_INTERVAL", "0s")
+	t.Setenv("MONGO_RECONNECT_MAX_BACKOFF", "0s")
+	setMinBackoff(t, 50*time.Millisecond)
+
+	db := crmgo.MustOpen("test")
+	defer db.Close()
+	sub, err := db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	time.Sleep(120 * time.Millisecond)
+	sub.Close()
+	test.LessOrEqual(atomic.LoadInt32(&drv.calls), int32(4)) // no hot loop
+	test.Nil(sub.Err())
+}
+
+func TestFeedQuery(t *testing.T) {
+	test := assert.New(t)
+
+	ops := bson.M{"$in": []string{"i", "u", "d"}}
+
+	// starting fresh, LogReplay needs a lower bound of ts
+	q, logReplay := crmgo.FeedQuery("app", "family", false, nil)
+	test.Equal(bson.M{"ns": "app.family", "op": ops}, q)
+	test.False(logReplay)
+
+	q, logReplay = crmgo.FeedQuery("app", "family", false, bson.MongoTimestamp(42))
+	test.Equal(bson.M{"ns": "app.family", "op": ops, "ts": bson.M{"$gt": bson.MongoTimestamp(42)}}, q)
+	test.True(logReplay)
+
+	q, logReplay = crmgo.FeedQuery("app", "events", true, "id1")
+	test.Equal(bson.M{"_id": bson.M{"$gt": "id1"}}, q)
+	test.False(logReplay)
+}
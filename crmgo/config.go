@@ -0,0 +1,253 @@
+package crmgo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cleverreach.com/crtools/crconfig"
+	"gopkg.in/mgo.v2"
+)
+
+// dialConfig is the connection configuration of one suffix
+type dialConfig struct {
+	info          *mgo.DialInfo
+	mode          mgo.Mode
+	socketTimeout time.Duration
+	poolTimeout   time.Duration
+	tls           bool
+	tlsInsecure   bool
+	tlsCAFile     string
+}
+
+// mgoOptions are the connection string options mgo.ParseURL knows, others make it fail
+var mgoOptions = map[string]bool{
+	"authSource":        true,
+	"authMechanism":     true,
+	"gssapiServiceName": true,
+	"replicaSet":        true,
+	"maxPoolSize":       true,
+	"connect":           true,
+}
+
+var readPreferences = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primarypreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondarypreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+	"eventual":           mgo.Eventual,
+	"monotonic":          mgo.Monotonic,
+	"strong":             mgo.Strong,
+}
+
+// key returns the config key for the suffix of m
+func (m *Multi) key(name string) string {
+	return name + m.suffix
+}
+
+// dialConfig reads the connection configuration from MONGO_URI or the single MONGO_* keys.
+// Timeouts, read preference and TLS keys are applied to MONGO_URI as well.
+func (m *Multi) dialConfig(dbname string) (*dialConfig, error) {
+	cfg := &dialConfig{
+		mode:          mgo.Monotonic,
+		socketTimeout: time.Minute,
+	}
+
+	if uri := crconfig.Get(m.key("MONGO_URI"), ""); uri != "" {
+		if err := cfg.parseURI(uri); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.info = &mgo.DialInfo{
+			Addrs:          m.addrs(),
+			Username:       crconfig.Get(m.key("MONGO_USER"), ""),
+			Password:       crconfig.Get(m.key("MONGO_PASSWORD"), ""),
+			Source:         crconfig.Get(m.key("MONGO_AUTH_SOURCE"), ""),
+			Mechanism:      crconfig.Get(m.key("MONGO_AUTH_MECHANISM"), ""),
+			ReplicaSetName: crconfig.Get(m.key("MONGO_REPLICA_SET"), ""),
+		}
+	}
+	if cfg.info.Database == "" {
+		cfg.info.Database = dbname
+	}
+	if cfg.info.Timeout == 0 {
+		cfg.info.Timeout = 10 * time.Second
+	}
+
+	cfg.info.Timeout = duration(m.key("MONGO_CONNECT_TIMEOUT"), cfg.info.Timeout)
+	cfg.socketTimeout = duration(m.key("MONGO_SOCKET_TIMEOUT"), cfg.socketTimeout)
+	cfg.poolTimeout = duration(m.key("MONGO_POOL_TIMEOUT"), 0)
+	if limit, err := strconv.Atoi(crconfig.Get(m.key("MONGO_POOL_LIMIT"), "")); err == nil {
+		cfg.info.PoolLimit = limit
+	}
+	if pref := crconfig.Get(m.key("MONGO_READ_PREFERENCE"), ""); pref != "" {
+		if err := cfg.setMode(pref); err != nil {
+			return nil, err
+		}
+	}
+	if val := crconfig.Get(m.key("MONGO_TLS"), ""); val != "" {
+		cfg.tls = isTrue(val)
+	}
+	cfg.tlsInsecure = isTrue(crconfig.Get(m.key("MONGO_TLS_INSECURE"), ""))
+	cfg.tlsCAFile = crconfig.Get(m.key("MONGO_TLS_CA_FILE"), "")
+
+	if cfg.tls {
+		if err := cfg.setTLS(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// addrs returns the hosts of MONGO_HOST, where hosts without port get MONGO_PORT
+func (m *Multi) addrs() []string {
+	host := crconfig.Get(m.key("MONGO_HOST"), "localhost")
+	port := crconfig.Get(m.key("MONGO_PORT"), "27017")
+
+	var res []string
+	for _, h := range strings.Split(host, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			h = net.JoinHostPort(h, port)
+		}
+		res = append(res, h)
+	}
+	return res
+}
+
+// parseURI parses a mongodb:// connection string.
+// Options mgo does not know are handled here, or ignored if they don't apply to mgo, like retryWrites or w.
+func (cfg *dialConfig) parseURI(uri string) error {
+	base, query := uri, ""
+	if pos := strings.Index(uri, "?"); pos >= 0 {
+		base, query = uri[:pos], uri[pos+1:]
+	}
+	opts, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid mongo uri options: %s", err.Error())
+	}
+
+	var connectTimeout time.Duration
+	for key, vals := range opts {
+		val := vals[0]
+		switch key {
+		case "ssl", "tls":
+			cfg.tls = isTrue(val)
+		case "tlsInsecure", "tlsAllowInvalidCertificates":
+			cfg.tlsInsecure = isTrue(val)
+		case "tlsCAFile":
+			cfg.tlsCAFile = val
+		case "readPreference":
+			if err := cfg.setMode(val); err != nil {
+				return err
+			}
+		case "connectTimeoutMS", "socketTimeoutMS":
+			ms, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("invalid mongo uri option %s", key)
+			}
+			if key == "socketTimeoutMS" {
+				cfg.socketTimeout = time.Duration(ms) * time.Millisecond
+			} else {
+				connectTimeout = time.Duration(ms) * time.Millisecond
+			}
+		default:
+			if mgoOptions[key] {
+				continue
+			}
+			Logger.Debugln("ignoring mongo uri option", key+"="+val, "not supported by mgo")
+		}
+		opts.Del(key)
+	}
+
+	if len(opts) > 0 {
+		base += "?" + opts.Encode()
+	}
+	cfg.info, err = mgo.ParseURL(base)
+	if err != nil {
+		return fmt.Errorf("invalid mongo uri: %s", err.Error())
+	}
+	cfg.info.Timeout = connectTimeout
+	return nil
+}
+
+func (cfg *dialConfig) setMode(pref string) error {
+	mode, ok := readPreferences[strings.ToLower(pref)]
+	if !ok {
+		return fmt.Errorf("unknown mongo read preference %s", pref)
+	}
+	cfg.mode = mode
+	return nil
+}
+
+// setTLS makes the connection use TLS
+func (cfg *dialConfig) setTLS() error {
+	conf := &tls.Config{InsecureSkipVerify: cfg.tlsInsecure}
+	if cfg.tlsCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.tlsCAFile)
+		if err != nil {
+			return err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", cfg.tlsCAFile)
+		}
+	}
+
+	info := cfg.info
+	cfg.info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+		c := conf.Clone()
+		c.ServerName, _, _ = net.SplitHostPort(addr.String())
+		// the timeout is read on dialing, it may be shortened to the deadline of OpenContext after setTLS
+		return tls.DialWithDialer(&net.Dialer{Timeout: info.Timeout}, "tcp", addr.String(), c)
+	}
+	return nil
+}
+
+// String describes the connection for logging, without credentials
+func (cfg *dialConfig) String() string {
+	res := "mongodb://" + strings.Join(cfg.info.Addrs, ",") + "/" + cfg.info.Database
+	if cfg.info.Username != "" {
+		res += " as " + cfg.info.Username
+	}
+	if cfg.info.ReplicaSetName != "" {
+		res += " replica set " + cfg.info.ReplicaSetName
+	}
+	if cfg.tls {
+		res += " with tls"
+	}
+	return res
+}
+
+// duration gets a duration like "10s" from crconfig, bare numbers are seconds.
+// Values which can not be parsed are logged and def is used.
+func duration(key string, def time.Duration) time.Duration {
+	val := crconfig.Get(key, "")
+	if val == "" {
+		return def
+	}
+	d, err := crconfig.ParseDuration(val, time.Second)
+	if err != nil {
+		warn("invalid duration", key, "=", val, "using", def)
+		return def
+	}
+	return d
+}
+
+func isTrue(val string) bool {
+	switch strings.ToLower(val) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
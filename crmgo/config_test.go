@@ -0,0 +1,162 @@
+package crmgo_test
+
+import (
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+)
+
+func TestAddrs(t *testing.T) {
+	tests := []struct {
+		host, port string
+		addrs      []string
+	}{
+		{"", "", []string{"localhost:27017"}},
+		{"db", "", []string{"db:27017"}},
+		{"db", "27018", []string{"db:27018"}},
+		{"a, b:27019,,c", "27018", []string{"a:27018", "b:27019", "c:27018"}},
+		{"::1", "", []string{"[::1]:27017"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			t.Setenv("MONGO_HOST_ADDRS", tt.host)
+			t.Setenv("MONGO_PORT_ADDRS", tt.port)
+			assert.Equal(t, tt.addrs, crmgo.Addrs("addrs"))
+		})
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		err  bool
+		want func(test *assert.Assertions, s crmgo.DialSettings)
+	}{
+		{uri: "mongodb://user:pass@h1,h2:27018/admin?replicaSet=rs0", want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal([]string{"h1", "h2:27018"}, s.Info.Addrs)
+			test.Equal("user", s.Info.Username)
+			test.Equal("pass", s.Info.Password)
+			test.Equal("admin", s.Info.Database)
+			test.Equal("rs0", s.Info.ReplicaSetName)
+			test.False(s.TLS)
+		}},
+		{uri: "mongodb://h/?tls=true&tlsInsecure=true&tlsCAFile=/ca.pem", want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.True(s.TLS)
+			test.True(s.TLSInsecure)
+			test.Equal("/ca.pem", s.TLSCAFile)
+		}},
+		{uri: "mongodb://h/?connectTimeoutMS=1500&socketTimeoutMS=30000", want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal(1500*time.Millisecond, s.Info.Timeout)
+			test.Equal(30*time.Second, s.SocketTimeout)
+		}},
+		{uri: "mongodb://h/?readPreference=secondaryPreferred", want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal(mgo.SecondaryPreferred, s.Mode)
+		}},
+		{uri: "mongodb://h/?readPreference=somewhere", err: true},
+		{uri: "mongodb://h/?socketTimeoutMS=1m", err: true},
+		{uri: "mongodb://h/app?retryWrites=true&w=majority&appName=x&replicaSet=rs0", want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal([]string{"h"}, s.Info.Addrs)
+			test.Equal("app", s.Info.Database)
+			test.Equal("rs0", s.Info.ReplicaSetName)
+		}},
+		{uri: "mongodb://h/?maxPoolSize=many", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			test := assert.New(t)
+			s, err := crmgo.ParseURI(tt.uri)
+			if tt.err {
+				test.NotNil(err)
+				return
+			}
+			if test.Nil(err) {
+				tt.want(test, s)
+			}
+		})
+	}
+}
+
+func TestDialConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  bool
+		want func(test *assert.Assertions, s crmgo.DialSettings)
+	}{
+		{name: "defaults", want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal([]string{"localhost:27017"}, s.Info.Addrs)
+			test.Equal("app", s.Info.Database)
+			test.Equal(10*time.Second, s.Info.Timeout)
+			test.Equal(time.Minute, s.SocketTimeout)
+			test.Equal(time.Duration(0), s.PoolTimeout)
+			test.Equal(mgo.Monotonic, s.Mode)
+			test.False(s.TLS)
+		}},
+		{name: "keys", env: map[string]string{
+			"MONGO_HOST_DIAL":            "db",
+			"MONGO_USER_DIAL":            "user",
+			"MONGO_REPLICA_SET_DIAL":     "rs0",
+			"MONGO_POOL_LIMIT_DIAL":      "10",
+			"MONGO_READ_PREFERENCE_DIAL": "nearest",
+		}, want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal([]string{"db:27017"}, s.Info.Addrs)
+			test.Equal("user", s.Info.Username)
+			test.Equal("rs0", s.Info.ReplicaSetName)
+			test.Equal(10, s.Info.PoolLimit)
+			test.Equal(mgo.Nearest, s.Mode)
+		}},
+		{name: "durations", env: map[string]string{
+			"MONGO_CONNECT_TIMEOUT_DIAL": "2s",
+			"MONGO_SOCKET_TIMEOUT_DIAL":  "30",
+			"MONGO_POOL_TIMEOUT_DIAL":    "1m30s",
+		}, want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal(2*time.Second, s.Info.Timeout)
+			test.Equal(30*time.Second, s.SocketTimeout)
+			test.Equal(90*time.Second, s.PoolTimeout)
+		}},
+		{name: "invalid duration", env: map[string]string{
+			"MONGO_SOCKET_TIMEOUT_DIAL": "half a minute",
+		}, want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal(time.Minute, s.SocketTimeout)
+		}},
+		{name: "uri", env: map[string]string{
+			"MONGO_URI_DIAL":             "mongodb://h1,h2/other?socketTimeoutMS=5000",
+			"MONGO_HOST_DIAL":            "ignored",
+			"MONGO_CONNECT_TIMEOUT_DIAL": "3s",
+			"MONGO_TLS_DIAL":             "yes",
+			"MONGO_TLS_INSECURE_DIAL":    "true",
+		}, want: func(test *assert.Assertions, s crmgo.DialSettings) {
+			test.Equal([]string{"h1", "h2"}, s.Info.Addrs)
+			test.Equal("other", s.Info.Database)
+			test.Equal(3*time.Second, s.Info.Timeout)
+			test.Equal(5*time.Second, s.SocketTimeout)
+			test.True(s.TLS)
+			test.True(s.TLSInsecure)
+			test.NotNil(s.Info.DialServer)
+		}},
+		{name: "unknown read preference", env: map[string]string{"MONGO_READ_PREFERENCE_DIAL": "somewhere"}, err: true},
+		{name: "missing ca file", env: map[string]string{
+			"MONGO_TLS_DIAL":         "true",
+			"MONGO_TLS_CA_FILE_DIAL": "testdata/missing.pem",
+		}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := assert.New(t)
+			for key, val := range tt.env {
+				t.Setenv(key, val)
+			}
+			s, err := crmgo.DialConfig("dial", "app")
+			if tt.err {
+				test.NotNil(err)
+				return
+			}
+			if test.Nil(err) {
+				tt.want(test, s)
+			}
+		})
+	}
+}
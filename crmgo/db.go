@@ -1,8 +1,11 @@
 package crmgo
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"strings"
+	"time"
 
 	"cleverreach.com/crtools/crconfig"
 	"gopkg.in/mgo.v2"
@@ -22,6 +25,20 @@ func (l *defaultLog) Debugln(args ...interface{}) {
 	}
 }
 
+// Warnln writes warnings to stderr, even without Debug
+func (l *defaultLog) Warnln(args ...interface{}) {
+	log.Println(args...)
+}
+
+// warn logs by Warnln, if Logger implements WarnLogger, otherwise by Debugln
+func warn(args ...interface{}) {
+	if w, ok := Logger.(WarnLogger); ok {
+		w.Warnln(args...)
+	} else {
+		Logger.Debugln(args...)
+	}
+}
+
 var (
 	// Debug can be set to true for retrieving debug information
 	Debug bool
@@ -31,8 +48,13 @@ var (
 
 // DB represents and encapsulates the mongo db
 type DB struct {
-	session *mgo.Session
-	dbName  string
+	driver Driver
+	dbName string
+	health *healthChecker
+	// maxQueryTime is the default time limit of operations
+	maxQueryTime time.Duration
+	// slowQuery is the duration from which operations are logged as slow
+	slowQuery time.Duration
 }
 
 // Multi is a helper struct to open a suffixed connection
@@ -48,26 +70,29 @@ func WithSuffix(suffix string) *Multi {
 	return &Multi{suffix}
 }
 
-// Open opens the mongo connection
+// Open opens the mongo connection.
+// It uses the driver set by MONGO_DRIVER, default is "mgo", configured by the keys in README.
 func (m *Multi) Open(dbname string) (*DB, error) {
-	host := crconfig.Get("MONGO_HOST"+m.suffix, "localhost")
-	port := crconfig.Get("MONGO_PORT"+m.suffix, "27017")
-	dialup := "mongodb://" + host + ":" + port
-
-	Logger.Debugln("dialup database", dialup)
-	sess, err := mgo.Dial(dialup + "/" + dbname)
-	if err == nil {
-		err = sess.Ping()
-	}
-	if err == nil {
-		sess.SetMode(mgo.Monotonic, true)
-		return &DB{
-			session: sess,
-			dbName:  dbname,
-		}, nil
-	}
+	return m.OpenContext(context.Background(), dbname)
+}
 
-	return nil, err
+// OpenContext opens the mongo connection like Open, but gives up when ctx is done
+func (m *Multi) OpenContext(ctx context.Context, dbname string) (*DB, error) {
+	drv, err := openDriver(ctx, crconfig.Get(m.key("MONGO_DRIVER"), "mgo"), m.suffix, dbname)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{
+		driver:       drv,
+		dbName:       dbname,
+		maxQueryTime: duration(m.key("MONGO_MAX_QUERY_TIME"), 0),
+		slowQuery:    duration(m.key("MONGO_SLOW_QUERY_THRESHOLD"), time.Second),
+		health: newHealthChecker(drv,
+			duration(m.key("MONGO_HEALTH_INTERVAL"), 10*time.Second),
+			duration(m.key("MONGO_HEALTH_TIMEOUT"), 5*time.Second),
+			duration(m.key("MONGO_RECONNECT_MAX_BACKOFF"), time.Minute),
+		),
+	}, nil
 }
 
 // MustOpen opens the DB Connection and panics on errors
@@ -85,6 +110,12 @@ func Open(dbname string) (*DB, error) {
 	return m.Open(dbname)
 }
 
+// OpenContext opens the mongo db, but gives up when ctx is done
+func OpenContext(ctx context.Context, dbname string) (*DB, error) {
+	m := &Multi{}
+	return m.OpenContext(ctx, dbname)
+}
+
 // MustOpen opens the DB Connection and panics on errors
 func MustOpen(dbname string) *DB {
 	m := &Multi{}
@@ -93,24 +124,62 @@ func MustOpen(dbname string) *DB {
 
 // Close closes the db session
 func (d *DB) Close() {
-	d.session.Close()
+	d.health.close()
+	d.driver.Close()
 	Logger.Debugln("Closed database", d.dbName)
 }
 
 // Drop drops the database, be careful!
 func (d *DB) Drop() error {
-	d.session.Refresh()
-	ok := d.session.DB(d.dbName).DropDatabase()
+	return d.DropContext(context.Background())
+}
+
+// DropContext drops the database like Drop, but gives up when ctx is done
+func (d *DB) DropContext(ctx context.Context) error {
+	ok := d.driver.Drop(ctx)
 	d.Close()
 	Logger.Debugln("Dropped database", d.dbName)
 	return ok
 }
 
+// Collection gets the driver independent Collection to make the queries on.
+// Operations without an earlier deadline in their context are limited to MONGO_MAX_QUERY_TIME.
+// Every operation is reported to OperationHook and the metrics.
+func (d *DB) Collection(name string) Collection {
+	return &instrumentedCollection{
+		Collection: &deadlineCollection{Collection: d.driver.Collection(name), maxTime: d.maxQueryTime},
+		db:         d.dbName,
+		name:       name,
+		threshold:  d.slowQuery,
+	}
+}
+
 // C gets the mgo Collection to make the queries on.
+// It works with the mgo driver only and panics otherwise.
+//
+// Deprecated: Use Collection, which works with every driver.
 func (d *DB) C(name string) *mgo.Collection {
-	if err := d.session.Ping(); err != nil {
-		d.session.Refresh()
-		Logger.Debugln("refreshed mongo session")
+	return d.mgo().C(name)
+}
+
+// CContext gets the mgo Collection for operations with the deadline of ctx,
+// or MONGO_MAX_QUERY_TIME, and a func to call when done with it.
+// It works with the mgo driver only and panics otherwise.
+//
+// Deprecated: Use Collection, which works with every driver.
+func (d *DB) CContext(ctx context.Context, name string) (*mgo.Collection, func()) {
+	ctx, cancel := deadline(ctx, d.maxQueryTime)
+	coll, release := d.mgo().CContext(ctx, name)
+	return coll, func() {
+		release()
+		cancel()
+	}
+}
+
+func (d *DB) mgo() *mgoDriver {
+	md, ok := d.driver.(*mgoDriver)
+	if !ok {
+		panic("crmgo: C needs the mgo driver, use Collection")
 	}
-	return d.session.DB(d.dbName).C(name)
+	return md
 }
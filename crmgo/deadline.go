@@ -0,0 +1,89 @@
+package crmgo
+
+import (
+	"context"
+	"time"
+)
+
+// deadlineCollection limits operations without an earlier deadline to a default max time
+type deadlineCollection struct {
+	Collection
+	maxTime time.Duration
+}
+
+// deadline returns ctx with a timeout of maxTime, unless it has an earlier deadline or maxTime is 0
+func deadline(ctx context.Context, maxTime time.Duration) (context.Context, context.CancelFunc) {
+	if maxTime <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, maxTime)
+}
+
+// findDeadline applies the shorter of the default and the MaxTime of opts
+func (c *deadlineCollection) findDeadline(ctx context.Context, opts *FindOptions) (context.Context, context.CancelFunc) {
+	maxTime := c.maxTime
+	if opts != nil && opts.MaxTime > 0 && (maxTime <= 0 || opts.MaxTime < maxTime) {
+		maxTime = opts.MaxTime
+	}
+	return deadline(ctx, maxTime)
+}
+
+func (c *deadlineCollection) Find(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	ctx, cancel := c.findDeadline(ctx, opts)
+	defer cancel()
+	return c.Collection.Find(ctx, filter, opts, result)
+}
+
+func (c *deadlineCollection) FindOne(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	ctx, cancel := c.findDeadline(ctx, opts)
+	defer cancel()
+	return c.Collection.FindOne(ctx, filter, opts, result)
+}
+
+func (c *deadlineCollection) Insert(ctx context.Context, docs ...interface{}) error {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.Insert(ctx, docs...)
+}
+
+func (c *deadlineCollection) Update(ctx context.Context, filter, update interface{}, opts *UpdateOptions) (*UpdateResult, error) {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.Update(ctx, filter, update, opts)
+}
+
+func (c *deadlineCollection) Delete(ctx context.Context, filter interface{}, multi bool) (int, error) {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.Delete(ctx, filter, multi)
+}
+
+func (c *deadlineCollection) Aggregate(ctx context.Context, pipeline interface{}, result interface{}) error {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.Aggregate(ctx, pipeline, result)
+}
+
+func (c *deadlineCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.Count(ctx, filter)
+}
+
+func (c *deadlineCollection) Indexes(ctx context.Context) ([]Index, error) {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.Indexes(ctx)
+}
+
+func (c *deadlineCollection) CreateIndex(ctx context.Context, index Index) error {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.CreateIndex(ctx, index)
+}
+
+func (c *deadlineCollection) DropIndex(ctx context.Context, name string) error {
+	ctx, cancel := deadline(ctx, c.maxTime)
+	defer cancel()
+	return c.Collection.DropIndex(ctx, name)
+}
@@ -0,0 +1,116 @@
+package crmgo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowCollection blocks until the context is done
+type slowCollection struct {
+	crmgo.Collection
+}
+
+func (c *slowCollection) Find(ctx context.Context, filter interface{}, opts *crmgo.FindOptions, result interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *slowCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (c *slowCollection) CreateIndex(ctx context.Context, index crmgo.Index) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type slowDriver struct {
+	flakyDriver
+}
+
+func (d *slowDriver) Collection(name string) crmgo.Collection { return &slowCollection{} }
+
+func TestDeadlines(t *testing.T) {
+	test := assert.New(t)
+
+	crmgo.RegisterDriver("slow", func(ctx context.Context, suffix, dbname string) (crmgo.Driver, error) {
+		return &slowDriver{}, ctx.Err()
+	})
+	t.Setenv("MONGO_DRIVER", "slow")
+	t.Setenv("MONGO_MAX_QUERY_TIME", "50ms")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := crmgo.OpenContext(ctx, "test")
+	test.Equal(context.Canceled, err)
+
+	db, err := crmgo.OpenContext(context.Background(), "test")
+	if !test.Nil(err) {
+		return
+	}
+	defer db.Close()
+	coll := db.Collection("slow")
+
+	elapsed := func(fn func() error) time.Duration {
+		start := time.Now()
+		test.Equal(context.DeadlineExceeded, fn())
+		return time.Since(start)
+	}
+
+	// default max query time
+	test.GreaterOrEqual(int64(elapsed(func() error {
+		_, err := coll.Count(context.Background(), nil)
+		return err
+	})), int64(50*time.Millisecond))
+
+	// index operations as well
+	test.GreaterOrEqual(int64(elapsed(func() error {
+		return coll.CreateIndex(context.Background(), crmgo.Index{Key: []string{"a"}})
+	})), int64(50*time.Millisecond))
+
+	// shorter MaxTime of the query
+	test.Less(int64(elapsed(func() error {
+		return coll.Find(context.Background(), nil, &crmgo.FindOptions{MaxTime: time.Millisecond}, nil)
+	})), int64(50*time.Millisecond))
+
+	// shorter deadline of the context
+	test.Less(int64(elapsed(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		return coll.Find(ctx, nil, nil, nil)
+	})), int64(50*time.Millisecond))
+}
+
+func TestWithContextCancel(t *testing.T) {
+	test := assert.New(t)
+
+	// a find, which is still decoding when ctx is canceled
+	var result []member
+	started, finished := make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	docs, err := crmgo.WithContext(ctx, func() ([]member, error) {
+		defer close(finished)
+		var docs []member
+		close(started)
+		for i := 0; i < 100; i++ {
+			docs = append(docs, member{ID: "m"})
+			time.Sleep(time.Millisecond)
+		}
+		return docs, nil
+	})
+	test.Equal(context.Canceled, err)
+	test.Nil(docs)
+
+	result = append(result, member{ID: "caller"}) // the background find must not write to it
+	<-finished
+	test.Len(result, 1)
+}
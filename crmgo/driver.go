@@ -0,0 +1,157 @@
+package crmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned if no document matches, e.g. on FindOne
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateKey is returned on inserts or updates violating a unique index
+	ErrDuplicateKey = errors.New("duplicate key")
+)
+
+type (
+	// Collection is the driver independent access to a collection.
+	// Filters are usually a Q, results pointers to a struct or a slice of structs.
+	Collection interface {
+		// Name returns the name of the collection
+		Name() string
+		// Find finds all documents matching filter into result, which must be a pointer to a slice
+		Find(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error
+		// FindOne finds the first document matching filter into result, or returns ErrNotFound
+		FindOne(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error
+		// Insert inserts one or more documents
+		Insert(ctx context.Context, docs ...interface{}) error
+		// Update applies update to the documents matching filter
+		Update(ctx context.Context, filter, update interface{}, opts *UpdateOptions) (*UpdateResult, error)
+		// Delete deletes the first or, if multi, all documents matching filter and returns how many
+		Delete(ctx context.Context, filter interface{}, multi bool) (int, error)
+		// Aggregate runs the aggregation pipeline into result, which must be a pointer to a slice
+		Aggregate(ctx context.Context, pipeline interface{}, result interface{}) error
+		// Count counts the documents matching filter
+		Count(ctx context.Context, filter interface{}) (int, error)
+		// Indexes lists the indexes of the collection
+		Indexes(ctx context.Context) ([]Index, error)
+		// CreateIndex creates an index
+		CreateIndex(ctx context.Context, index Index) error
+		// DropIndex drops the index called name
+		DropIndex(ctx context.Context, name string) error
+	}
+
+	// Driver is implemented by the database drivers crmgo can use
+	Driver interface {
+		// Collection returns the collection called name
+		Collection(name string) Collection
+		// Ping checks the connection
+		Ping(ctx context.Context) error
+		// Drop drops the whole database
+		Drop(ctx context.Context) error
+		// Close closes the connection
+		Close()
+	}
+
+	// ChangeFeed may be implemented by a Driver, which can tail the changes of collections
+	// If capped, the capped collection itself is tailed, which only gets inserts.
+	ChangeFeed interface {
+		// LastChange returns the token of the last change, empty if there is none
+		LastChange(ctx context.Context, collection string, capped bool) (string, error)
+		// Changes sends the changes of collection after token, or all kept if token is empty,
+		// to ch until ctx is done or an error occurs
+		Changes(ctx context.Context, collection, token string, capped bool, ch chan<- Change) error
+	}
+
+	// OpenFunc opens a Driver for database dbname, giving up when ctx is done.
+	// suffix is the suffix of the config keys, like "_SPECIAL" for MONGO_HOST_SPECIAL, or empty.
+	OpenFunc func(ctx context.Context, suffix, dbname string) (Driver, error)
+
+	// FindOptions are optional for finding documents
+	FindOptions struct {
+		// Sort by these fields, prefix a field by '-' for descending order
+		Sort []string
+		// Skip this many documents
+		Skip int
+		// Limit to this many documents, 0 is no limit
+		Limit int
+		// Projection selects the fields to return, e.g. Q{"name": 1}
+		Projection interface{}
+		// MaxTime limits the time of the query, if shorter than MONGO_MAX_QUERY_TIME and the deadline of the context
+		MaxTime time.Duration
+	}
+
+	// UpdateOptions are optional for updating documents
+	UpdateOptions struct {
+		// Multi updates all matching documents instead of the first one
+		Multi bool
+		// Upsert inserts a document, if none matches
+		Upsert bool
+	}
+
+	// Index is an index of a collection
+	Index struct {
+		// Name of the index
+		Name string
+		// Key are the indexed fields, prefix a field by '-' for descending order
+		Key []string
+		// Unique prevents documents with the same key
+		Unique bool
+		// Sparse only indexes documents having the fields
+		Sparse bool
+		// ExpireAfter makes a TTL index, deleting documents when the time in the field is older
+		ExpireAfter time.Duration
+	}
+
+	// Change is an insert, update or delete of a document
+	Change struct {
+		// Op is "insert", "update" or "delete"
+		Op string
+		// Collection is the name of the changed collection
+		Collection string
+		// ID is the _id of the document
+		ID interface{}
+		// Doc is the inserted document, with embedded documents as maps as well
+		Doc map[string]interface{}
+		// Update describes an update like in the oplog, e.g. {"$set": {"name": "Tim"}},
+		// or is the whole document, if it was replaced
+		Update map[string]interface{}
+		// Token identifies the change to continue after it
+		Token string
+	}
+
+	// UpdateResult tells what an update did
+	UpdateResult struct {
+		// Matched documents
+		Matched int
+		// Updated documents
+		Updated int
+		// UpsertedID is the id of the inserted document on upserts
+		UpsertedID interface{}
+	}
+)
+
+var (
+	driverMutex sync.RWMutex
+	drivers     = map[string]OpenFunc{}
+)
+
+// RegisterDriver registers a driver to be used if MONGO_DRIVER is set to name.
+// The default driver is "mgo".
+func RegisterDriver(name string, open OpenFunc) {
+	driverMutex.Lock()
+	defer driverMutex.Unlock()
+	drivers[name] = open
+}
+
+func openDriver(ctx context.Context, name, suffix, dbname string) (Driver, error) {
+	driverMutex.RLock()
+	open, ok := drivers[name]
+	driverMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown mongo driver %s", name)
+	}
+	return open(ctx, suffix, dbname)
+}
@@ -0,0 +1,71 @@
+package crmgo
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DialSettings is the part of dialConfig checked by the tests
+type DialSettings struct {
+	Info          *mgo.DialInfo
+	Mode          mgo.Mode
+	SocketTimeout time.Duration
+	PoolTimeout   time.Duration
+	TLS           bool
+	TLSInsecure   bool
+	TLSCAFile     string
+}
+
+func settings(cfg *dialConfig) DialSettings {
+	return DialSettings{
+		Info:          cfg.info,
+		Mode:          cfg.mode,
+		SocketTimeout: cfg.socketTimeout,
+		PoolTimeout:   cfg.poolTimeout,
+		TLS:           cfg.tls,
+		TLSInsecure:   cfg.tlsInsecure,
+		TLSCAFile:     cfg.tlsCAFile,
+	}
+}
+
+// DialConfig reads the connection configuration of suffix
+func DialConfig(suffix, dbname string) (DialSettings, error) {
+	cfg, err := WithSuffix(suffix).dialConfig(dbname)
+	if err != nil {
+		return DialSettings{}, err
+	}
+	return settings(cfg), nil
+}
+
+// ParseURI parses a connection string
+func ParseURI(uri string) (DialSettings, error) {
+	cfg := &dialConfig{}
+	if err := cfg.parseURI(uri); err != nil {
+		return DialSettings{}, err
+	}
+	return settings(cfg), nil
+}
+
+// Addrs returns the hosts configured for suffix
+func Addrs(suffix string) []string {
+	return WithSuffix(suffix).addrs()
+}
+
+// MinBackoff is the shortest wait before retrying
+var MinBackoff = &minBackoff
+
+// WithContext runs fn until ctx is done, like the mgo driver does for operations
+func WithContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	return withContext(ctx, fn)
+}
+
+// MemoryChangesKept is the number of changes the memory driver keeps
+var MemoryChangesKept = &memoryChangesKept
+
+// FeedQuery returns the query of the change feed of the mgo driver and whether it uses LogReplay
+func FeedQuery(dbname, collection string, capped bool, after interface{}) (bson.M, bool) {
+	return (&mgoDriver{dbName: dbname}).feedQuery(collection, capped, after)
+}
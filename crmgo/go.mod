@@ -1,9 +1,11 @@
 module cleverreach.com/crtools/crmgo
 
-go 1.14
+go 1.18
 
 require (
-	cleverreach.com/crtools/crconfig v1.0.1
+	cleverreach.com/crtools/crconfig v1.1.0
+	cleverreach.com/crtools/meta v1.0.0
+	github.com/stretchr/testify v1.6.1
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -0,0 +1,155 @@
+package crmgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Health is the status of a connection, e.g. for readiness probes
+type Health struct {
+	// Healthy is true, if the last check succeeded
+	Healthy bool
+	// Error of the last failed check
+	Error string
+	// Since is the time the status changed last
+	Since time.Time
+	// LastCheck is the time of the last check
+	LastCheck time.Time
+	// Failures is the number of consecutive failed checks
+	Failures int
+}
+
+// minBackoff is the shortest wait before retrying, even if MONGO_RECONNECT_MAX_BACKOFF is less
+var minBackoff = time.Second
+
+// backoff returns the wait after the given number of consecutive failures:
+// 1s, 2s, 4s... up to max, but never less than minBackoff
+func backoff(failures int, max time.Duration) time.Duration {
+	wait := time.Second << uint(failures-1)
+	if wait > max || wait <= 0 {
+		wait = max
+	}
+	if wait < minBackoff {
+		wait = minBackoff
+	}
+	return wait
+}
+
+// reconnecter is implemented by drivers, which can reconnect after failures
+type reconnecter interface {
+	Reconnect()
+}
+
+// healthChecker checks the connection in the background and reconnects with backoff on failures
+type healthChecker struct {
+	driver     Driver
+	interval   time.Duration
+	timeout    time.Duration
+	maxBackoff time.Duration
+
+	mutex     sync.RWMutex
+	health    Health
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHealthChecker(drv Driver, interval, timeout, maxBackoff time.Duration) *healthChecker {
+	now := time.Now()
+	h := &healthChecker{
+		driver:     drv,
+		interval:   interval,
+		timeout:    timeout,
+		maxBackoff: maxBackoff,
+		health:     Health{Healthy: true, Since: now, LastCheck: now}, // opening pings
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if interval > 0 {
+		go h.run()
+	} else {
+		close(h.done)
+	}
+	return h
+}
+
+func (h *healthChecker) run() {
+	defer close(h.done)
+	wait := h.interval
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-h.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		failures := h.check()
+		if failures == 0 {
+			wait = h.interval
+			continue
+		}
+		if r, ok := h.driver.(reconnecter); ok {
+			r.Reconnect()
+		}
+		wait = backoff(failures, h.maxBackoff)
+	}
+}
+
+// check pings the server and returns the number of consecutive failures
+func (h *healthChecker) check() int {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	res := make(chan error, 1)
+	go func() { res <- h.driver.Ping(ctx) }()
+	var err error
+	select {
+	case err = <-res:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := time.Now()
+	h.health.LastCheck = now
+	if err == nil {
+		if !h.health.Healthy {
+			Logger.Debugln("mongo connection is healthy again")
+			h.health = Health{Healthy: true, Since: now, LastCheck: now}
+		}
+		return 0
+	}
+
+	if h.health.Healthy {
+		Logger.Debugln("mongo connection is unhealthy:", err)
+		h.health.Healthy = false
+		h.health.Since = now
+	}
+	h.health.Error = err.Error()
+	h.health.Failures++
+	return h.health.Failures
+}
+
+func (h *healthChecker) get() Health {
+	if h.interval <= 0 {
+		h.check() // no background checks
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.health
+}
+
+// close stops the background checks, it may be called concurrently and repeatedly
+func (h *healthChecker) close() {
+	h.closeOnce.Do(func() { close(h.stop) })
+	<-h.done
+}
+
+// Health returns the status of the connection, checked in the background every MONGO_HEALTH_INTERVAL
+func (d *DB) Health() Health {
+	return d.health.get()
+}
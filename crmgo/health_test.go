@@ -0,0 +1,112 @@
+package crmgo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyDriver struct {
+	failing    int32
+	reconnects int32
+}
+
+func (d *flakyDriver) Collection(name string) crmgo.Collection { return nil }
+func (d *flakyDriver) Drop(ctx context.Context) error          { return nil }
+func (d *flakyDriver) Close()                                  {}
+func (d *flakyDriver) Reconnect()                              { atomic.AddInt32(&d.reconnects, 1) }
+func (d *flakyDriver) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&d.failing) == 1 {
+		return errors.New("no reachable servers")
+	}
+	return nil
+}
+
+func setMinBackoff(t *testing.T, d time.Duration) {
+	prev := *crmgo.MinBackoff
+	*crmgo.MinBackoff = d
+	t.Cleanup(func() { *crmgo.MinBackoff = prev })
+}
+
+func TestHealth(t *testing.T) {
+	test := assert.New(t)
+
+	drv := &flakyDriver{}
+	crmgo.RegisterDriver("flaky", func(ctx context.Context, suffix, dbname string) (crmgo.Driver, error) { return drv, nil })
+	t.Setenv("MONGO_DRIVER", "flaky")
+	t.Setenv("MONGO_HEALTH_INTERVAL", "10ms")
+	t.Setenv("MONGO_RECONNECT_MAX_BACKOFF", "20ms")
+	setMinBackoff(t, time.Millisecond)
+
+	db := crmgo.MustOpen("test")
+	defer db.Close()
+	test.True(db.Health().Healthy)
+
+	waitFor := func(healthy bool) crmgo.Health {
+		for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(5 * time.Millisecond) {
+			if h := db.Health(); h.Healthy == healthy {
+				return h
+			}
+		}
+		t.Fatal("health didn't change")
+		return crmgo.Health{}
+	}
+
+	atomic.StoreInt32(&drv.failing, 1)
+	h := waitFor(false)
+	test.Equal("no reachable servers", h.Error)
+	test.GreaterOrEqual(h.Failures, 1)
+	time.Sleep(50 * time.Millisecond)
+	test.Greater(atomic.LoadInt32(&drv.reconnects), int32(1))
+
+	atomic.StoreInt32(&drv.failing, 0)
+	h = waitFor(true)
+	test.Equal(0, h.Failures)
+	test.Empty(h.Error)
+}
+
+func TestHealthWithoutInterval(t *testing.T) {
+	test := assert.New(t)
+
+	drv := &flakyDriver{failing: 1}
+	crmgo.RegisterDriver("flaky", func(ctx context.Context, suffix, dbname string) (crmgo.Driver, error) { return drv, nil })
+	t.Setenv("MONGO_DRIVER", "flaky")
+	t.Setenv("MONGO_HEALTH_INTERVAL", "0s")
+
+	db := crmgo.MustOpen("test")
+	defer db.Close()
+	test.False(db.Health().Healthy)
+}
+
+func TestHealthMinBackoff(t *testing.T) {
+	test := assert.New(t)
+
+	drv := &flakyDriver{failing: 1}
+	crmgo.RegisterDriver("flaky", func(ctx context.Context, suffix, dbname string) (crmgo.Driver, error) { return drv, nil })
+	t.Setenv("MONGO_DRIVER", "flaky")
+	t.Setenv("MONGO_HEALTH_INTERVAL", "1ms")
+	t.Setenv("MONGO_RECONNECT_MAX_BACKOFF", "0s")
+	setMinBackoff(t, 50*time.Millisecond)
+
+	db := crmgo.MustOpen("test")
+	time.Sleep(120 * time.Millisecond)
+	test.LessOrEqual(atomic.LoadInt32(&drv.reconnects), int32(3)) // no hot loop
+
+	// concurrent and repeated closing is fine
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.Close()
+		}()
+	}
+	wg.Wait()
+	db.Close()
+}
@@ -0,0 +1,293 @@
+package crmgo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// IndexOptions change what EnsureIndexes does
+type IndexOptions struct {
+	// DropUnknown drops indexes not declared by the struct
+	DropUnknown bool
+	// DryRun only reports what would be done
+	DryRun bool
+}
+
+// IndexReport tells what EnsureIndexes did or, on a dry run, would do
+type IndexReport struct {
+	Collection string
+	DryRun     bool
+	// Created are the missing indexes and the changed ones, which are dropped before
+	Created []Index
+	// Dropped are the changed indexes and the unknown ones, if dropping them was requested
+	Dropped []Index
+	// Unknown are indexes not declared by the struct
+	Unknown []Index
+	// Unchanged are indexes as declared
+	Unchanged []Index
+}
+
+// String returns the report for logging, with one line per change
+func (r *IndexReport) String() string {
+	b := &strings.Builder{}
+	prefix := ""
+	if r.DryRun {
+		prefix = "(dry run) "
+	}
+	for _, i := range r.Dropped {
+		fmt.Fprintf(b, "%s%s: - %s\n", prefix, r.Collection, i)
+	}
+	for _, i := range r.Created {
+		fmt.Fprintf(b, "%s%s: + %s\n", prefix, r.Collection, i)
+	}
+	for _, i := range r.Unknown {
+		if !containsIndex(r.Dropped, i.Name) {
+			fmt.Fprintf(b, "%s%s: ? %s\n", prefix, r.Collection, i)
+		}
+	}
+	if b.Len() == 0 {
+		fmt.Fprintf(b, "%s%s: indexes are up to date\n", prefix, r.Collection)
+	}
+	return b.String()
+}
+
+// String describes the index like "email (email) unique"
+func (i Index) String() string {
+	s := i.Name + " (" + strings.Join(i.Key, ", ") + ")"
+	if i.Unique {
+		s += " unique"
+	}
+	if i.Sparse {
+		s += " sparse"
+	}
+	if i.ExpireAfter > 0 {
+		s += " ttl=" + i.ExpireAfter.String()
+	}
+	return s
+}
+
+func (i Index) equal(o Index) bool {
+	return i.Name == o.Name && i.sameKey(o) &&
+		i.Unique == o.Unique && i.Sparse == o.Sparse && i.ExpireAfter == o.ExpireAfter
+}
+
+// sameKey tells whether both indexes have the same key spec, mongo allows only one index per key spec
+func (i Index) sameKey(o Index) bool {
+	if len(i.Key) != len(o.Key) {
+		return false
+	}
+	for n := range i.Key {
+		if strings.TrimPrefix(i.Key[n], "+") != strings.TrimPrefix(o.Key[n], "+") {
+			return false
+		}
+	}
+	return true
+}
+
+// indexName returns the name mongo gives an index by default, like "email_1_created_-1"
+func indexName(key []string) string {
+	parts := make([]string, len(key))
+	for i, field := range key {
+		if strings.HasPrefix(field, "-") {
+			parts[i] = field[1:] + "_-1"
+		} else {
+			parts[i] = strings.TrimPrefix(field, "+") + "_1"
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func containsIndex(list []Index, name string) bool {
+	for _, i := range list {
+		if i.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexesOf returns the indexes declared by the index tags of the struct doc.
+// A tag is the name of the index followed by options, e.g. `index:"email,unique"`:
+//
+//	unique    prevents documents with the same key
+//	sparse    only indexes documents having the fields
+//	desc      sorts the field descending
+//	ttl=24h   deletes documents when the time in the field is older, in whole seconds
+//
+// Fields with the same index name make up a compound index in the order of the fields.
+// Multiple indexes of a field are separated by ';' like `index:"email,unique;email_created"`.
+func IndexesOf(doc interface{}) ([]Index, error) {
+	t := reflect.TypeOf(doc)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("indexes need a struct, not %v", t)
+	}
+
+	var list []Index
+	byName := map[string]int{}
+	err := indexFields(t, "", map[reflect.Type]bool{}, func(path, tag string) error {
+		for _, spec := range strings.Split(tag, ";") {
+			opts := strings.Split(spec, ",")
+			name := strings.TrimSpace(opts[0])
+			if name == "" {
+				return fmt.Errorf("index of %s has no name", path)
+			}
+			pos, ok := byName[name]
+			if !ok {
+				pos = len(list)
+				byName[name] = pos
+				list = append(list, Index{Name: name})
+			}
+			index := &list[pos]
+
+			field := path
+			for _, opt := range opts[1:] {
+				switch opt = strings.TrimSpace(opt); {
+				case opt == "unique":
+					index.Unique = true
+				case opt == "sparse":
+					index.Sparse = true
+				case opt == "desc":
+					field = "-" + path
+				case strings.HasPrefix(opt, "ttl="):
+					ttl, err := time.ParseDuration(opt[4:])
+					if err != nil || ttl <= 0 || ttl%time.Second != 0 {
+						// mongo keeps whole seconds, anything else would never match the existing index
+						return fmt.Errorf("index %s: invalid ttl %s, needs whole seconds", name, opt[4:])
+					}
+					index.ExpireAfter = ttl
+				default:
+					return fmt.Errorf("index %s: unknown option %s", name, opt)
+				}
+			}
+			index.Key = append(index.Key, field)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, index := range list {
+		if index.ExpireAfter > 0 && len(index.Key) > 1 {
+			return nil, fmt.Errorf("index %s: ttl needs a single field", index.Name)
+		}
+	}
+	return list, nil
+}
+
+// indexFields calls fn with the bson path and index tag of all fields having one, including nested structs.
+// Types already on the path are not entered again, so self-referential types like trees end there.
+func indexFields(t reflect.Type, prefix string, visiting map[reflect.Type]bool, fn func(path, tag string) error) error {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		btag := field.Tag.Get("bson")
+		if field.PkgPath != "" || btag == "-" {
+			continue
+		}
+		path := prefix + bsonName(field)
+		if strings.Contains(btag, ",inline") {
+			path = strings.TrimSuffix(prefix, ".")
+		}
+		if tag, ok := field.Tag.Lookup("index"); ok {
+			if err := fn(path, tag); err != nil {
+				return err
+			}
+		}
+
+		ft := field.Type
+		for ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array || ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+			sub := path + "."
+			if path == "" {
+				sub = ""
+			}
+			if err := indexFields(ft, sub, visiting, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EnsureIndexes makes the indexes of the collection match the ones declared by the struct doc, see IndexesOf.
+// Existing indexes are matched by their key first, then by name, so an index mongo named like "email_1" is found as well.
+// Missing indexes are created, changed or renamed ones recreated and unknown ones dropped, if told so.
+func EnsureIndexes(ctx context.Context, coll Collection, doc interface{}, opts IndexOptions) (*IndexReport, error) {
+	declared, err := IndexesOf(doc)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := coll.Indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IndexReport{Collection: coll.Name(), DryRun: opts.DryRun}
+	matched := map[string]bool{}
+	drop := func(e Index) {
+		if !containsIndex(report.Dropped, e.Name) {
+			report.Dropped = append(report.Dropped, e)
+		}
+	}
+	for _, index := range declared {
+		var found []Index
+		for _, e := range existing {
+			if e.sameKey(index) || e.Name == index.Name {
+				found = append(found, e)
+				matched[e.Name] = true
+			}
+		}
+		if len(found) == 1 && found[0].equal(index) {
+			report.Unchanged = append(report.Unchanged, index)
+			continue
+		}
+		for _, e := range found {
+			drop(e)
+		}
+		report.Created = append(report.Created, index)
+	}
+	for _, e := range existing {
+		if e.Name != "_id_" && !matched[e.Name] {
+			report.Unknown = append(report.Unknown, e)
+			if opts.DropUnknown {
+				drop(e)
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	for _, index := range report.Dropped {
+		Logger.Debugln("drop index", coll.Name(), index)
+		if err = coll.DropIndex(ctx, index.Name); err != nil {
+			return report, fmt.Errorf("drop index %s: %w", index.Name, err)
+		}
+	}
+	for _, index := range report.Created {
+		Logger.Debugln("create index", coll.Name(), index)
+		if err = coll.CreateIndex(ctx, index); err != nil {
+			return report, fmt.Errorf("create index %s: %w", index.Name, err)
+		}
+	}
+	return report, nil
+}
+
+// EnsureIndexes makes the indexes of the collection match the index tags of T, see IndexesOf
+func (r *Repository[T]) EnsureIndexes(ctx context.Context, opts IndexOptions) (*IndexReport, error) {
+	return EnsureIndexes(ctx, r.coll, new(T), opts)
+}
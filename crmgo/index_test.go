@@ -0,0 +1,125 @@
+package crmgo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+type account struct {
+	ID      string    `bson:"_id"`
+	Email   string    `bson:"email" index:"email,unique"`
+	Client  int       `bson:"client" index:"client_created"`
+	Created time.Time `bson:"created" index:"client_created,desc;expire,ttl=24h"`
+	Profile struct {
+		Nick string `bson:"nick" index:"nick,sparse"`
+	} `bson:"profile"`
+}
+
+type node struct {
+	Name     string  `bson:"name" index:"name"`
+	Parent   *node   `bson:"parent"`
+	Children []*node `bson:"children"`
+	Meta     struct {
+		Owner *node `bson:"owner"`
+	} `bson:"meta"`
+}
+
+func TestIndexesOf(t *testing.T) {
+	test := assert.New(t)
+
+	list, err := crmgo.IndexesOf(account{})
+	test.Nil(err)
+	test.Equal([]crmgo.Index{
+		{Name: "email", Key: []string{"email"}, Unique: true},
+		{Name: "client_created", Key: []string{"client", "-created"}},
+		{Name: "expire", Key: []string{"created"}, ExpireAfter: 24 * time.Hour},
+		{Name: "nick", Key: []string{"profile.nick"}, Sparse: true},
+	}, list)
+
+	_, err = crmgo.IndexesOf(struct {
+		A int `index:"a,ttl=1h"`
+		B int `index:"a"`
+	}{})
+	test.EqualError(err, "index a: ttl needs a single field")
+	_, err = crmgo.IndexesOf(struct {
+		A int `index:"a,uniq"`
+	}{})
+	test.EqualError(err, "index a: unknown option uniq")
+	_, err = crmgo.IndexesOf(struct {
+		A time.Time `index:"a,ttl=1500ms"`
+	}{})
+	test.EqualError(err, "index a: invalid ttl 1500ms, needs whole seconds")
+
+	// self-referential types end at the first repetition
+	list, err = crmgo.IndexesOf(node{})
+	test.Nil(err)
+	test.Equal([]crmgo.Index{{Name: "name", Key: []string{"name"}}}, list)
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	db := openMemory(t)
+	coll := db.Collection("accounts")
+	test.Nil(coll.CreateIndex(ctx, crmgo.Index{Name: "old", Key: []string{"old"}}))
+	test.Nil(coll.CreateIndex(ctx, crmgo.Index{Name: "email", Key: []string{"email"}}))
+
+	report, err := crmgo.EnsureIndexes(ctx, coll, &account{}, crmgo.IndexOptions{DryRun: true})
+	test.Nil(err)
+	test.Equal("(dry run) accounts: - email (email)\n"+
+		"(dry run) accounts: + email (email) unique\n"+
+		"(dry run) accounts: + client_created (client, -created)\n"+
+		"(dry run) accounts: + expire (created) ttl=24h0m0s\n"+
+		"(dry run) accounts: + nick (profile.nick) sparse\n"+
+		"(dry run) accounts: ? old (old)\n", report.String())
+	list, _ := coll.Indexes(ctx)
+	test.Len(list, 3)
+
+	repo := crmgo.NewRepository[account](db, "accounts")
+	report, err = repo.EnsureIndexes(ctx, crmgo.IndexOptions{DropUnknown: true})
+	test.Nil(err)
+	test.Len(report.Created, 4)
+	test.Len(report.Dropped, 2)
+	list, _ = coll.Indexes(ctx)
+	test.Len(list, 5)
+
+	report, err = repo.EnsureIndexes(ctx, crmgo.IndexOptions{})
+	test.Nil(err)
+	test.Len(report.Unchanged, 4)
+	test.Equal("accounts: indexes are up to date\n", report.String())
+
+	test.Nil(repo.Insert(ctx, account{ID: "1", Email: "a@example.com"}, account{ID: "2", Email: "b@example.com"}))
+	test.Equal(crmgo.ErrDuplicateKey, repo.Insert(ctx, account{ID: "3", Email: "a@example.com"}))
+	_, err = repo.Update(ctx, crmgo.Q{"ID": "2"}, crmgo.U{}.Set("Email", "a@example.com"), nil)
+	test.Equal(crmgo.ErrDuplicateKey, err)
+	_, err = repo.Update(ctx, crmgo.Q{"ID": "2"}, crmgo.U{}.Set("Email", "c@example.com"), nil)
+	test.Nil(err)
+}
+
+func TestEnsureIndexesByKey(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	coll := openMemory(t).Collection("accounts")
+	test.Nil(coll.CreateIndex(ctx, crmgo.Index{Key: []string{"email"}, Unique: true}))
+	test.Nil(coll.CreateIndex(ctx, crmgo.Index{Name: "expire", Key: []string{"created"}, ExpireAfter: 24 * time.Hour}))
+
+	// mongo allows one index per key
+	test.NotNil(coll.CreateIndex(ctx, crmgo.Index{Name: "email", Key: []string{"email"}, Unique: true}))
+
+	report, err := crmgo.EnsureIndexes(ctx, coll, &account{}, crmgo.IndexOptions{})
+	test.Nil(err)
+	test.Equal([]crmgo.Index{{Name: "email_1", Key: []string{"email"}, Unique: true}}, report.Dropped)
+	test.Len(report.Created, 3)
+	test.Empty(report.Unknown)
+	test.Equal([]crmgo.Index{{Name: "expire", Key: []string{"created"}, ExpireAfter: 24 * time.Hour}}, report.Unchanged)
+
+	report, err = crmgo.EnsureIndexes(ctx, coll, &account{}, crmgo.IndexOptions{})
+	test.Nil(err)
+	test.Len(report.Unchanged, 4)
+}
@@ -0,0 +1,1220 @@
+package crmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memoryDriver is a Driver keeping all documents in memory, for tests.
+// Databases are shared by name until dropped.
+type memoryDriver struct {
+	db *memoryDB
+}
+
+type memoryDB struct {
+	mutex       sync.RWMutex
+	name        string
+	collections map[string][]bson.M
+	indexes     map[string][]Index
+	changes     []memoryChange
+	seq         int64
+	changed     chan struct{} // closed and replaced on every change
+}
+
+// memoryChange is a Change in the change feed of a memoryDB
+type memoryChange struct {
+	seq    int64
+	change Change
+}
+
+// memoryChangesKept is the number of changes kept for subscriptions, like the size of the oplog
+var memoryChangesKept = 10000
+
+type memoryCollection struct {
+	db   *memoryDB
+	name string
+}
+
+// ErrUnsupported is returned by drivers not supporting an operator or pipeline stage
+var ErrUnsupported = errors.New("not supported by driver")
+
+var (
+	memoryMutex sync.Mutex
+	memoryDBs   = map[string]*memoryDB{}
+)
+
+func init() {
+	RegisterDriver("memory", openMemory)
+}
+
+func openMemory(ctx context.Context, suffix, dbname string) (Driver, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+
+	db, ok := memoryDBs[dbname]
+	if !ok {
+		db = &memoryDB{name: dbname, collections: map[string][]bson.M{}, indexes: map[string][]Index{}, changed: make(chan struct{})}
+		memoryDBs[dbname] = db
+	}
+	return &memoryDriver{db}, nil
+}
+
+func (d *memoryDriver) Collection(name string) Collection {
+	return &memoryCollection{db: d.db, name: name}
+}
+
+func (d *memoryDriver) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (d *memoryDriver) Drop(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.db.mutex.Lock()
+	d.db.collections = map[string][]bson.M{}
+	d.db.indexes = map[string][]Index{}
+	d.db.mutex.Unlock()
+	return nil
+}
+
+func (d *memoryDriver) Close() {}
+
+func (c *memoryCollection) Name() string {
+	return c.name
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	docs, err := c.find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	return decodeAll(docs, result)
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	one := FindOptions{Limit: 1}
+	if opts != nil {
+		one = *opts
+		one.Limit = 1
+	}
+	docs, err := c.find(ctx, filter, &one)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return ErrNotFound
+	}
+	return decode(docs[0], result)
+}
+
+func (c *memoryCollection) find(ctx context.Context, filter interface{}, opts *FindOptions) ([]bson.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.db.mutex.RLock()
+	docs, err := filterDocs(c.db.collections[c.name], f)
+	if err == nil {
+		docs, err = copyDocs(docs)
+	}
+	c.db.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		return docs, nil
+	}
+	sortDocs(docs, opts.Sort)
+	docs = page(docs, opts.Skip, opts.Limit)
+	if opts.Projection != nil {
+		p, err := toDoc(opts.Projection)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range docs {
+			docs[i] = project(doc, p)
+		}
+	}
+	return docs, nil
+}
+
+func (c *memoryCollection) Insert(ctx context.Context, docs ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.db.mutex.Lock()
+	defer c.db.mutex.Unlock()
+
+	for _, d := range docs {
+		doc, err := toDoc(d)
+		if err != nil {
+			return err
+		}
+		if err = c.insert(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert adds doc with a new _id, if it has none. The lock must be held.
+func (c *memoryCollection) insert(doc bson.M) error {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	if err := c.checkUnique(doc, nil, c.allIndexes()); err != nil {
+		return err
+	}
+	c.db.collections[c.name] = append(c.db.collections[c.name], doc)
+	c.record(Change{Op: "insert", ID: doc["_id"], Doc: doc})
+	return nil
+}
+
+// record adds a change to the change feed and wakes up subscriptions. The lock must be held.
+func (c *memoryCollection) record(change Change) {
+	change.Collection = c.name
+	change.Doc = plainDoc(change.Doc)
+	change.Update = plainDoc(change.Update)
+	c.db.seq++
+	change.Token = strconv.FormatInt(c.db.seq, 10)
+	c.db.changes = append(c.db.changes, memoryChange{seq: c.db.seq, change: change})
+	if len(c.db.changes) > memoryChangesKept {
+		c.db.changes = c.db.changes[len(c.db.changes)-memoryChangesKept:]
+	}
+	close(c.db.changed)
+	c.db.changed = make(chan struct{})
+}
+
+// copyDocs deep copies docs, so neither changes of the results nor later updates interfere
+func copyDocs(docs []bson.M) ([]bson.M, error) {
+	res := make([]bson.M, len(docs))
+	for i, doc := range docs {
+		var err error
+		if res[i], err = toDoc(doc); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (d *memoryDriver) LastChange(ctx context.Context, collection string, capped bool) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	d.db.mutex.RLock()
+	defer d.db.mutex.RUnlock()
+	if d.db.seq == 0 {
+		return "", nil
+	}
+	return strconv.FormatInt(d.db.seq, 10), nil
+}
+
+// Changes tails the changes kept of the database, which are inserts only on capped
+func (d *memoryDriver) Changes(ctx context.Context, collection, token string, capped bool, ch chan<- Change) error {
+	var after int64
+	if token != "" {
+		var err error
+		if after, err = strconv.ParseInt(token, 10, 64); err != nil || after < 0 {
+			return ErrInvalidToken
+		}
+		d.db.mutex.RLock()
+		lost := len(d.db.changes) > 0 && after < d.db.changes[0].seq-1
+		d.db.mutex.RUnlock()
+		if lost {
+			return fmt.Errorf("%w: the kept changes start after it", ErrInvalidToken)
+		}
+	}
+	for {
+		var pending []Change
+		d.db.mutex.RLock()
+		for _, c := range d.db.changes {
+			if c.seq > after && c.change.Collection == collection && (!capped || c.change.Op == "insert") {
+				pending = append(pending, c.change)
+			}
+		}
+		if d.db.seq > after {
+			after = d.db.seq
+		}
+		changed := d.db.changed
+		d.db.mutex.RUnlock()
+
+		for _, c := range pending {
+			c.Doc = plainDoc(c.Doc)
+			c.Update = plainDoc(c.Update)
+			select {
+			case ch <- c:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// allIndexes returns the indexes including the one of _id. The lock must be held.
+func (c *memoryCollection) allIndexes() []Index {
+	return append([]Index{{Name: "_id_", Key: []string{"_id"}, Unique: true}}, c.db.indexes[c.name]...)
+}
+
+// checkUnique returns ErrDuplicateKey, if another document than the one with selfID
+// has the same key of a unique index as doc. The lock must be held.
+func (c *memoryCollection) checkUnique(doc bson.M, selfID interface{}, indexes []Index) error {
+	for _, index := range indexes {
+		if !index.Unique {
+			continue
+		}
+		key, ok := indexKey(doc, index)
+		if !ok {
+			continue
+		}
+		for _, other := range c.db.collections[c.name] {
+			if selfID != nil && equal(other["_id"], selfID) {
+				continue
+			}
+			if k, ok := indexKey(other, index); ok && equalKeys(k, key) {
+				return ErrDuplicateKey
+			}
+		}
+	}
+	return nil
+}
+
+// indexKey returns the values of the indexed fields, false for sparse indexes on documents without them
+func indexKey(doc bson.M, index Index) ([]interface{}, bool) {
+	key := make([]interface{}, len(index.Key))
+	anyFound := false
+	for i, field := range index.Key {
+		val, found := lookup(doc, strings.TrimLeft(field, "+-"))
+		key[i] = val
+		anyFound = anyFound || found
+	}
+	return key, anyFound || !index.Sparse
+}
+
+func equalKeys(a, b []interface{}) bool {
+	for i := range a {
+		if !equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *memoryCollection) Indexes(ctx context.Context) ([]Index, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mutex.RLock()
+	defer c.db.mutex.RUnlock()
+	return c.allIndexes(), nil
+}
+
+func (c *memoryCollection) CreateIndex(ctx context.Context, index Index) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if index.Name == "" {
+		index.Name = indexName(index.Key)
+	}
+	index.ExpireAfter = index.ExpireAfter.Truncate(time.Second) // like mongo does
+	c.db.mutex.Lock()
+	defer c.db.mutex.Unlock()
+
+	for _, i := range c.allIndexes() {
+		if i.equal(index) {
+			return nil
+		}
+		// mongo fails with code 85 or 86
+		if i.Name == index.Name {
+			return fmt.Errorf("index %s exists with different options", index.Name)
+		}
+		if i.sameKey(index) {
+			return fmt.Errorf("index %s exists with the same key as %s", i.Name, index.Name)
+		}
+	}
+	for _, doc := range c.db.collections[c.name] {
+		if err := c.checkUnique(doc, doc["_id"], []Index{index}); err != nil {
+			return err
+		}
+	}
+	c.db.indexes[c.name] = append(c.db.indexes[c.name], index)
+	return nil
+}
+
+func (c *memoryCollection) DropIndex(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.db.mutex.Lock()
+	defer c.db.mutex.Unlock()
+
+	indexes := c.db.indexes[c.name]
+	for i, index := range indexes {
+		if index.Name == name {
+			c.db.indexes[c.name] = append(indexes[:i:i], indexes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("index not found with name %s", name)
+}
+
+func (c *memoryCollection) Update(ctx context.Context, filter, update interface{}, opts *UpdateOptions) (*UpdateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+
+	c.db.mutex.Lock()
+	defer c.db.mutex.Unlock()
+
+	change, err := toDoc(u)
+	if err != nil {
+		return nil, err
+	}
+	delete(change, "$setOnInsert")
+
+	res := &UpdateResult{}
+	docs, err := filterDocs(c.db.collections[c.name], f)
+	if err != nil {
+		return nil, err
+	}
+	indexes := c.allIndexes()
+	for _, doc := range docs {
+		res.Matched++
+		updated, err := toDoc(doc)
+		if err != nil {
+			return res, err
+		}
+		if err = applyUpdate(updated, u, false); err != nil {
+			return res, err
+		}
+		if err = c.checkUnique(updated, doc["_id"], indexes); err != nil {
+			return res, err
+		}
+		for key := range doc {
+			delete(doc, key)
+		}
+		for key, val := range updated {
+			doc[key] = val
+		}
+		res.Updated++
+		c.record(Change{Op: "update", ID: doc["_id"], Update: change})
+		if !opts.Multi {
+			break
+		}
+	}
+
+	if res.Matched == 0 && opts.Upsert {
+		doc := bson.M{}
+		for key, val := range f {
+			if _, isOp := operators(val); !isOp && !strings.HasPrefix(key, "$") {
+				if err := setPath(doc, key, val); err != nil {
+					return res, err
+				}
+			}
+		}
+		if err = applyUpdate(doc, u, true); err != nil {
+			return res, err
+		}
+		if err = c.insert(doc); err != nil {
+			return res, err
+		}
+		res.UpsertedID = doc["_id"]
+	}
+	return res, nil
+}
+
+func (c *memoryCollection) Delete(ctx context.Context, filter interface{}, multi bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.db.mutex.Lock()
+	defer c.db.mutex.Unlock()
+
+	var deleted []interface{}
+	kept := make([]bson.M, 0, len(c.db.collections[c.name]))
+	for _, doc := range c.db.collections[c.name] {
+		if len(deleted) == 0 || multi {
+			ok, err := match(doc, f)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				deleted = append(deleted, doc["_id"])
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	c.db.collections[c.name] = kept
+	for _, id := range deleted {
+		c.record(Change{Op: "delete", ID: id})
+	}
+	return len(deleted), nil
+}
+
+// Aggregate supports the stages $match, $sort, $skip and $limit only
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline interface{}, result interface{}) error {
+	// decoding into bson.D keeps the order of the fields to sort by
+	var stages struct {
+		P []bson.D `bson:"p"`
+	}
+	data, err := bson.Marshal(bson.M{"p": pipeline})
+	if err != nil {
+		return err
+	}
+	if err = bson.Unmarshal(data, &stages); err != nil {
+		return err
+	}
+
+	docs, err := c.find(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	for _, stage := range stages.P {
+		if len(stage) != 1 {
+			return fmt.Errorf("invalid pipeline stage %v", stage)
+		}
+		for _, e := range stage {
+			name, arg := e.Name, e.Value
+			switch name {
+			case "$match":
+				f, err := toDoc(arg)
+				if err != nil {
+					return err
+				}
+				if docs, err = filterDocs(docs, f); err != nil {
+					return err
+				}
+			case "$sort":
+				sortDocs(docs, sortFields(arg))
+			case "$skip":
+				docs = page(docs, int(number(arg)), 0)
+			case "$limit":
+				docs = page(docs, 0, int(number(arg)))
+			default:
+				return fmt.Errorf("stage %s: %w", name, ErrUnsupported)
+			}
+		}
+	}
+	return decodeAll(docs, result)
+}
+
+func (c *memoryCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	docs, err := c.find(ctx, filter, nil)
+	return len(docs), err
+}
+
+// toDoc converts any document, like a struct or Q, to bson.M, which is a deep copy then
+func toDoc(v interface{}) (bson.M, error) {
+	doc := bson.M{}
+	if v == nil {
+		return doc, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return doc, bson.Unmarshal(data, doc)
+}
+
+// decode decodes doc into result, a pointer to a struct or map
+func decode(doc bson.M, result interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+// decodeAll decodes docs into result, a pointer to a slice
+func decodeAll(docs []bson.M, result interface{}) error {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("result must be a pointer to a slice")
+	}
+	slice := reflect.MakeSlice(rv.Elem().Type(), 0, len(docs))
+	for _, doc := range docs {
+		elem := reflect.New(slice.Type().Elem())
+		if err := decode(doc, elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// filterDocs returns the docs matching filter.
+// These are the docs themselves, not copies: Update changes them in place, readers copy them by copyDocs.
+func filterDocs(docs []bson.M, filter bson.M) ([]bson.M, error) {
+	var found []bson.M
+	for _, doc := range docs {
+		ok, err := match(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, doc)
+		}
+	}
+	return found, nil
+}
+
+func page(docs []bson.M, skip, limit int) []bson.M {
+	if skip >= len(docs) {
+		return nil
+	}
+	docs = docs[skip:]
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// sortFields converts a $sort stage like {"a": 1, "b": -1} to FindOptions.Sort
+func sortFields(arg interface{}) []string {
+	var fields []string
+	switch s := arg.(type) {
+	case bson.M:
+		for key, dir := range s {
+			if number(dir) < 0 {
+				key = "-" + key
+			}
+			fields = append(fields, key)
+		}
+		sort.Strings(fields) // maps have no order
+	case bson.D:
+		for _, e := range s {
+			key := e.Name
+			if number(e.Value) < 0 {
+				key = "-" + key
+			}
+			fields = append(fields, key)
+		}
+	}
+	return fields
+}
+
+func sortDocs(docs []bson.M, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimLeft(field, "+-")
+			a, _ := lookup(docs[i], field)
+			b, _ := lookup(docs[j], field)
+			if c := compare(a, b); c != 0 {
+				return (c < 0) != desc
+			}
+		}
+		return false
+	})
+}
+
+func project(doc, projection bson.M) bson.M {
+	include := false
+	for key, val := range projection {
+		if key != "_id" && truthy(val) {
+			include = true
+		}
+	}
+	res := bson.M{}
+	if include {
+		for key, val := range projection {
+			if v, ok := doc[key]; ok && truthy(val) {
+				res[key] = v
+			}
+		}
+		if id, ok := projection["_id"]; !ok || truthy(id) {
+			res["_id"] = doc["_id"]
+		}
+		return res
+	}
+	for key, val := range doc {
+		if p, ok := projection[key]; !ok || truthy(p) {
+			res[key] = val
+		}
+	}
+	return res
+}
+
+func truthy(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return number(v) != 0
+}
+
+// match tells whether doc matches filter
+func match(doc, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("operator %s: %w", key, ErrUnsupported)
+			}
+			ok, err = matchField(doc, key, cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	list, ok := cond.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("%s needs an array", op)
+	}
+	for _, c := range list {
+		f, ok := c.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array of documents", op)
+		}
+		ok, err := match(doc, f)
+		if err != nil {
+			return false, err
+		}
+		if ok && op == "$or" {
+			return true, nil
+		}
+		if ok && op == "$nor" {
+			return false, nil
+		}
+		if !ok && op == "$and" {
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+func matchField(doc bson.M, path string, cond interface{}) (bool, error) {
+	val, found := lookup(doc, path)
+	return matchValue(val, found, cond)
+}
+
+// matchValue matches val against an operator document like {"$gt": 1} or by equality
+func matchValue(val interface{}, found bool, cond interface{}) (bool, error) {
+	ops, isOp := operators(cond)
+	if !isOp {
+		return matchOp(val, found, "$eq", cond)
+	}
+	for op, arg := range ops {
+		var ok bool
+		var err error
+		switch op {
+		case "$options":
+			continue // used by $regex
+		case "$regex":
+			if pattern, isStr := arg.(string); isStr {
+				options, _ := ops["$options"].(string)
+				arg = bson.RegEx{Pattern: pattern, Options: options}
+			}
+			ok, err = matchOp(val, found, "$eq", arg)
+		case "$not":
+			ok, err = matchValue(val, found, arg)
+			ok = !ok
+		case "$elemMatch":
+			ok, err = matchElem(val, arg)
+		default:
+			ok, err = matchOp(val, found, op, arg)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchElem tells whether any element of the array val matches cond
+func matchElem(val, cond interface{}) (bool, error) {
+	list, _ := val.([]interface{})
+	f, ok := cond.(bson.M)
+	if !ok {
+		return false, errors.New("$elemMatch needs a document")
+	}
+	_, isOp := operators(f)
+	for _, elem := range list {
+		var ok bool
+		var err error
+		if isOp {
+			ok, err = matchValue(elem, true, f)
+		} else if doc, isDoc := elem.(bson.M); isDoc {
+			ok, err = match(doc, f)
+		}
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// regex compiles a mongo regular expression, supporting the options i, m and s
+func regex(re bson.RegEx) (*regexp.Regexp, error) {
+	flags := ""
+	for _, o := range re.Options {
+		switch o {
+		case 'i', 'm', 's':
+			flags += string(o)
+		default:
+			return nil, fmt.Errorf("regex option %c: %w", o, ErrUnsupported)
+		}
+	}
+	if flags != "" {
+		return regexp.Compile("(?" + flags + ")" + re.Pattern)
+	}
+	return regexp.Compile(re.Pattern)
+}
+
+// operators returns cond as operator document, if it is one like {"$gt": 1}
+func operators(cond interface{}) (bson.M, bool) {
+	m, ok := cond.(bson.M)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for key := range m {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+func matchOp(val interface{}, found bool, op string, arg interface{}) (bool, error) {
+	switch op {
+	case "$eq":
+		if re, ok := arg.(bson.RegEx); ok {
+			r, err := regex(re)
+			if err != nil {
+				return false, err
+			}
+			return anyValue(val, func(v interface{}) bool {
+				s, ok := v.(string)
+				return ok && r.MatchString(s)
+			}), nil
+		}
+		return anyValue(val, func(v interface{}) bool { return equal(v, arg) }), nil
+	case "$ne", "$nin":
+		ok, err := matchOp(val, found, map[string]string{"$ne": "$eq", "$nin": "$in"}[op], arg)
+		return !ok, err
+	case "$exists":
+		return found == truthy(arg), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return anyValue(val, func(v interface{}) bool {
+			if !found || rank(v) != rank(arg) {
+				return false
+			}
+			c := compare(v, arg)
+			switch op {
+			case "$gt":
+				return c > 0
+			case "$gte":
+				return c >= 0
+			case "$lt":
+				return c < 0
+			}
+			return c <= 0
+		}), nil
+	case "$in":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, errors.New("$in needs an array")
+		}
+		return anyValue(val, func(v interface{}) bool {
+			for _, a := range list {
+				if equal(v, a) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+	return false, fmt.Errorf("operator %s: %w", op, ErrUnsupported)
+}
+
+// anyValue calls fn with val and, if it is an array, with every element of it
+func anyValue(val interface{}, fn func(interface{}) bool) bool {
+	if fn(val) {
+		return true
+	}
+	if list, ok := val.([]interface{}); ok {
+		for _, v := range list {
+			if fn(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup finds a dotted path like "address.city" in doc.
+// Paths through arrays of documents return the values of all elements.
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case bson.M:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			if i, err := strconv.Atoi(part); err == nil {
+				if i >= len(v) {
+					return nil, false
+				}
+				cur = v[i]
+				continue
+			}
+			var values []interface{}
+			for _, elem := range v {
+				if m, ok := elem.(bson.M); ok {
+					if next, ok := lookup(m, part); ok {
+						values = append(values, next)
+					}
+				}
+			}
+			if len(values) == 0 {
+				return nil, false
+			}
+			cur = values
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setPath sets a dotted path in doc, creating sub documents as needed.
+// Paths into arrays like "items.0.name" are not supported.
+func setPath(doc bson.M, path string, val interface{}) error {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, found := doc[part]
+		sub, ok := next.(bson.M)
+		switch {
+		case !found:
+			sub = bson.M{}
+			doc[part] = sub
+		case !ok:
+			return fmt.Errorf("path %s: %w", path, ErrUnsupported)
+		}
+		doc = sub
+	}
+	doc[parts[len(parts)-1]] = val
+	return nil
+}
+
+// unsetPath removes a dotted path from doc
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		sub, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = sub
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+// applyUpdate applies an update document to doc.
+// Without operators, the update replaces the document, keeping the _id.
+// $setOnInsert is applied only when inserting.
+func applyUpdate(doc, update bson.M, inserting bool) error {
+	if _, isOp := operators(update); !isOp {
+		id, hasID := doc["_id"]
+		for key := range doc {
+			delete(doc, key)
+		}
+		for key, val := range update {
+			doc[key] = val
+		}
+		if hasID {
+			doc["_id"] = id
+		}
+		return nil
+	}
+
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s needs a document", op)
+		}
+		if op == "$setOnInsert" {
+			if !inserting {
+				continue
+			}
+			op = "$set"
+		}
+		for path, val := range fields {
+			if err := updateField(doc, op, path, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func updateField(doc bson.M, op, path string, val interface{}) error {
+	cur, found := lookup(doc, path)
+	switch op {
+	case "$set":
+		return setPath(doc, path, val)
+	case "$unset":
+		unsetPath(doc, path)
+	case "$inc":
+		if !found {
+			cur = 0
+		}
+		sum, err := add(cur, val)
+		if err != nil {
+			return fmt.Errorf("$inc %s: %w", path, err)
+		}
+		return setPath(doc, path, sum)
+	case "$min", "$max":
+		c := compare(val, cur)
+		if !found || (op == "$min" && c < 0) || (op == "$max" && c > 0) {
+			return setPath(doc, path, val)
+		}
+	case "$currentDate":
+		now := time.Now()
+		if spec, ok := val.(bson.M); ok && spec["$type"] == "timestamp" {
+			return setPath(doc, path, bson.MongoTimestamp(now.Unix()<<32))
+		}
+		return setPath(doc, path, now)
+	case "$push", "$addToSet", "$pull":
+		list, ok := cur.([]interface{})
+		if found && !ok {
+			return fmt.Errorf("%s %s: not an array", op, path)
+		}
+		if op == "$pull" {
+			if !found {
+				return nil
+			}
+			return pull(doc, path, list, val)
+		}
+		items := []interface{}{val}
+		if mods, ok := operators(val); ok {
+			for mod := range mods {
+				if mod != "$each" {
+					return fmt.Errorf("%s modifier %s: %w", op, mod, ErrUnsupported)
+				}
+			}
+			items, _ = mods["$each"].([]interface{})
+		}
+		for _, item := range items {
+			if op == "$addToSet" && anyValue(list, func(v interface{}) bool { return equal(v, item) }) {
+				continue
+			}
+			list = append(list, item)
+		}
+		return setPath(doc, path, list)
+	default:
+		return fmt.Errorf("update operator %s: %w", op, ErrUnsupported)
+	}
+	return nil
+}
+
+// pull removes the elements of list matching cond from the array at path
+func pull(doc bson.M, path string, list []interface{}, cond interface{}) error {
+	f, isDoc := cond.(bson.M)
+	_, isOp := operators(cond)
+	// a query of array documents, which may combine queries by $or
+	isQuery := isDoc && (!isOp || f["$or"] != nil || f["$and"] != nil || f["$nor"] != nil)
+	kept := []interface{}{}
+	for _, elem := range list {
+		var ok bool
+		var err error
+		if isQuery {
+			if m, isDoc := elem.(bson.M); isDoc {
+				ok, err = match(m, f)
+			}
+		} else {
+			ok, err = matchValue(elem, true, cond)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			kept = append(kept, elem)
+		}
+	}
+	return setPath(doc, path, kept)
+}
+
+// add adds two numbers, keeping integers if both are
+func add(a, b interface{}) (interface{}, error) {
+	if !isNumber(a) || !isNumber(b) {
+		return nil, errors.New("not a number")
+	}
+	switch x := a.(type) {
+	case int:
+		if y, ok := b.(int); ok {
+			return x + y, nil
+		}
+		if y, ok := b.(int64); ok {
+			return int64(x) + y, nil
+		}
+	case int64:
+		if y, ok := b.(int); ok {
+			return x + int64(y), nil
+		}
+		if y, ok := b.(int64); ok {
+			return x + y, nil
+		}
+	}
+	return number(a) + number(b), nil
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, float64:
+		return true
+	}
+	return false
+}
+
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// rank orders the types like mongo does when sorting
+func rank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int, int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case []interface{}:
+		return 4
+	case bson.ObjectId:
+		return 5
+	case bool:
+		return 6
+	case time.Time:
+		return 7
+	}
+	return 8
+}
+
+// compare returns -1, 0 or 1 like strings.Compare
+func compare(a, b interface{}) int {
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case bson.ObjectId:
+		return strings.Compare(string(x), string(b.(bson.ObjectId)))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case y:
+			return -1
+		}
+		return 1
+	case time.Time:
+		y := b.(time.Time)
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	}
+	if ra == 1 {
+		x, y := number(a), number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func equal(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return number(a) == number(b)
+	}
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,174 @@
+package crmgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+type member struct {
+	ID   string   `bson:"_id,omitempty"`
+	Name string   `bson:"name"`
+	Age  int      `bson:"age"`
+	Tags []string `bson:"tags,omitempty"`
+}
+
+func openMemory(t *testing.T) *crmgo.DB {
+	t.Setenv("MONGO_DRIVER", "memory")
+	db, err := crmgo.Open("test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Drop() })
+	return db
+}
+
+func insertFamily(t *testing.T, family crmgo.Collection) {
+	err := family.Insert(context.Background(),
+		&member{ID: "mary", Name: "Mary", Age: 32, Tags: []string{"parent"}},
+		&member{ID: "george", Name: "George", Age: 33, Tags: []string{"parent"}},
+		&member{ID: "tim", Name: "Tim", Age: 5},
+		&member{ID: "lisa", Name: "Lisa", Age: 12},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryFind(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+
+	var found []member
+	test.Nil(family.Find(ctx, crmgo.Q{"age": crmgo.Q{"$gte": 12, "$lt": 33}}, &crmgo.FindOptions{Sort: []string{"age"}}, &found))
+	if test.Len(found, 2) {
+		test.Equal("lisa", found[0].ID)
+		test.Equal("mary", found[1].ID)
+	}
+
+	test.Nil(family.Find(ctx, nil, &crmgo.FindOptions{Sort: []string{"-age"}, Skip: 1, Limit: 2}, &found))
+	if test.Len(found, 2) {
+		test.Equal("mary", found[0].ID)
+		test.Equal("lisa", found[1].ID)
+	}
+
+	test.Nil(family.Find(ctx, crmgo.Q{"tags": "parent"}, nil, &found))
+	test.Len(found, 2)
+
+	test.Nil(family.Find(ctx, crmgo.Q{"name": crmgo.Q{"$in": []string{"Tim", "Lisa", "Bob"}}}, nil, &found))
+	test.Len(found, 2)
+
+	or := crmgo.Q{"$or": []crmgo.Q{{"age": crmgo.LT(10)}, {"name": "George"}}}
+	test.Nil(family.Find(ctx, or, &crmgo.FindOptions{Sort: []string{"name"}}, &found))
+	if test.Len(found, 2) {
+		test.Equal("george", found[0].ID)
+		test.Equal("tim", found[1].ID)
+	}
+
+	var one member
+	test.Nil(family.FindOne(ctx, crmgo.Q{"name": "Tim"}, nil, &one))
+	test.Equal(5, one.Age)
+	test.Equal(crmgo.ErrNotFound, family.FindOne(ctx, crmgo.Q{"name": "Bob"}, nil, &one))
+
+	n, err := family.Count(ctx, crmgo.Q{}.GT("age", 18))
+	test.Nil(err)
+	test.Equal(2, n)
+
+	err = family.Insert(ctx, &member{ID: "tim", Name: "Tim"})
+	test.Equal(crmgo.ErrDuplicateKey, err)
+
+	// results are copies, changing them leaves the stored docs alone
+	var raw crmgo.Q
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "mary"}, nil, &raw))
+	raw["tags"].([]interface{})[0] = "changed"
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "mary"}, nil, &one))
+	test.Equal([]string{"parent"}, one.Tags)
+
+	err = family.Find(ctx, crmgo.Q{"$where": "true"}, nil, &found)
+	test.True(errors.Is(err, crmgo.ErrUnsupported))
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+
+	res, err := family.Update(ctx, crmgo.Q{"_id": "tim"}, crmgo.Q{"$inc": crmgo.Q{"age": 1}, "$push": crmgo.Q{"tags": "kid"}}, nil)
+	test.Nil(err)
+	test.Equal(1, res.Updated)
+
+	var tim member
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "tim"}, nil, &tim))
+	test.Equal(6, tim.Age)
+	test.Equal([]string{"kid"}, tim.Tags)
+
+	res, err = family.Update(ctx, crmgo.Q{}.LT("age", 18), crmgo.Q{"$set": crmgo.Q{"name": "kid"}}, &crmgo.UpdateOptions{Multi: true})
+	test.Nil(err)
+	test.Equal(2, res.Matched)
+	n, _ := family.Count(ctx, crmgo.Q{"name": "kid"})
+	test.Equal(2, n)
+
+	res, err = family.Update(ctx, crmgo.Q{"_id": "bob"}, crmgo.Q{"$set": crmgo.Q{"age": 70}}, &crmgo.UpdateOptions{Upsert: true})
+	test.Nil(err)
+	test.Equal("bob", res.UpsertedID)
+
+	var bob member
+	test.Nil(family.FindOne(ctx, crmgo.Q{"age": 70}, nil, &bob))
+	test.Equal("bob", bob.ID)
+
+	n, err = family.Delete(ctx, crmgo.Q{}.GT("age", 30), true)
+	test.Nil(err)
+	test.Equal(3, n)
+	n, _ = family.Count(ctx, nil)
+	test.Equal(2, n)
+}
+
+func TestMemoryUpdateUnsupported(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+
+	updates := []crmgo.Q{
+		{"$set": crmgo.Q{"tags.0": "kid"}},
+		{"$set": crmgo.Q{"tags.0.name": "kid"}},
+		{"$push": crmgo.Q{"tags": crmgo.Q{"$each": []interface{}{"kid"}, "$slice": -5}}},
+		{"$push": crmgo.Q{"tags": crmgo.Q{"$each": []interface{}{"kid"}, "$sort": 1}}},
+	}
+	for _, u := range updates {
+		_, err := family.Update(ctx, crmgo.Q{"_id": "mary"}, u, nil)
+		test.True(errors.Is(err, crmgo.ErrUnsupported), "%v", u)
+	}
+
+	var mary member
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "mary"}, nil, &mary))
+	test.Equal([]string{"parent"}, mary.Tags)
+}
+
+func TestMemoryAggregate(t *testing.T) {
+	test := assert.New(t)
+
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+
+	var found []member
+	pipeline := []crmgo.Q{
+		{"$match": crmgo.Q{}.GT("age", 10)},
+		{"$sort": crmgo.Q{"age": -1}},
+		{"$limit": 2},
+	}
+	test.Nil(family.Aggregate(context.Background(), pipeline, &found))
+	if test.Len(found, 2) {
+		test.Equal("george", found[0].ID)
+		test.Equal("mary", found[1].ID)
+	}
+}
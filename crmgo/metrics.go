@@ -0,0 +1,366 @@
+package crmgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Operation describes a finished operation on a collection
+type Operation struct {
+	// DB is the name of the database
+	DB string
+	// Collection is the name of the collection
+	Collection string
+	// Op is one of find, findOne, insert, update, delete, aggregate, count,
+	// indexes, createIndex, dropIndex, lastChange or changes
+	Op string
+	// Duration is the time the operation took
+	Duration time.Duration
+	// Docs are the documents returned, inserted, updated, deleted or counted, the indexes listed or the changes delivered
+	Docs int
+	// Err is the error of the operation, if any
+	Err error
+	// Slow is true, if the operation took longer than MONGO_SLOW_QUERY_THRESHOLD
+	Slow bool
+
+	filter interface{}
+}
+
+// WarnLogger may be implemented by the Logger additionally, to get warnings about slow queries.
+// Otherwise they are logged by Debugln.
+type WarnLogger interface {
+	Warnln(...interface{})
+}
+
+// OperationHook is called after every operation, e.g. for structured logging.
+// The default logs them by Logger.Debugln, but the slow ones, which are logged as warning anyway.
+var OperationHook = func(op Operation) {
+	if !op.Slow {
+		Logger.Debugln(op)
+	}
+}
+
+// Filter returns the filter or pipeline of the operation as JSON, with all values replaced by "?"
+func (o Operation) Filter() string {
+	if o.filter == nil {
+		return "{}"
+	}
+	doc, err := toDoc(bson.M{"f": o.filter})
+	if err != nil {
+		return "?"
+	}
+	data, err := json.Marshal(redact(doc["f"]))
+	if err != nil {
+		return "?"
+	}
+	return string(data)
+}
+
+// String returns the operation for logging
+func (o Operation) String() string {
+	s := fmt.Sprintf("mongo %s %s.%s filter=%s docs=%d duration=%s", o.Op, o.DB, o.Collection, o.Filter(), o.Docs, o.Duration)
+	if o.Err != nil {
+		s += " error=" + o.Err.Error()
+	}
+	return s
+}
+
+// redact replaces all values of a document, keeping the fields and operators
+func redact(v interface{}) interface{} {
+	switch val := v.(type) {
+	case bson.M:
+		res := make(map[string]interface{}, len(val))
+		for key, sub := range val {
+			res[key] = redact(sub)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, sub := range val {
+			res[i] = redact(sub)
+		}
+		return res
+	}
+	return "?"
+}
+
+// instrumentedCollection reports every operation to OperationHook and the metrics
+type instrumentedCollection struct {
+	Collection
+	db        string
+	name      string
+	threshold time.Duration
+}
+
+func (c *instrumentedCollection) observe(op string, filter interface{}, start time.Time, docs int, err error) {
+	o := Operation{
+		DB:         c.db,
+		Collection: c.name,
+		Op:         op,
+		Duration:   time.Since(start),
+		Docs:       docs,
+		Err:        err,
+		filter:     filter,
+	}
+	o.Slow = c.threshold > 0 && o.Duration >= c.threshold
+	if o.Slow {
+		warn("slow", o)
+	}
+	metrics.add(o)
+	if OperationHook != nil {
+		OperationHook(o)
+	}
+}
+
+// length returns the length of the slice result points to
+func length(result interface{}) int {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Slice {
+		return rv.Elem().Len()
+	}
+	return 0
+}
+
+func (c *instrumentedCollection) Find(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	start := time.Now()
+	err := c.Collection.Find(ctx, filter, opts, result)
+	c.observe("find", filter, start, length(result), err)
+	return err
+}
+
+func (c *instrumentedCollection) FindOne(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	start := time.Now()
+	err := c.Collection.FindOne(ctx, filter, opts, result)
+	docs := 1
+	if err != nil {
+		docs = 0
+	}
+	c.observe("findOne", filter, start, docs, err)
+	return err
+}
+
+func (c *instrumentedCollection) Insert(ctx context.Context, docs ...interface{}) error {
+	start := time.Now()
+	err := c.Collection.Insert(ctx, docs...)
+	c.observe("insert", nil, start, len(docs), err)
+	return err
+}
+
+func (c *instrumentedCollection) Update(ctx context.Context, filter, update interface{}, opts *UpdateOptions) (*UpdateResult, error) {
+	start := time.Now()
+	res, err := c.Collection.Update(ctx, filter, update, opts)
+	docs := 0
+	if res != nil {
+		docs = res.Updated
+		if res.UpsertedID != nil {
+			docs++
+		}
+	}
+	c.observe("update", filter, start, docs, err)
+	return res, err
+}
+
+func (c *instrumentedCollection) Delete(ctx context.Context, filter interface{}, multi bool) (int, error) {
+	start := time.Now()
+	n, err := c.Collection.Delete(ctx, filter, multi)
+	c.observe("delete", filter, start, n, err)
+	return n, err
+}
+
+func (c *instrumentedCollection) Aggregate(ctx context.Context, pipeline interface{}, result interface{}) error {
+	start := time.Now()
+	err := c.Collection.Aggregate(ctx, pipeline, result)
+	c.observe("aggregate", pipeline, start, length(result), err)
+	return err
+}
+
+func (c *instrumentedCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	start := time.Now()
+	n, err := c.Collection.Count(ctx, filter)
+	c.observe("count", filter, start, n, err)
+	return n, err
+}
+
+func (c *instrumentedCollection) Indexes(ctx context.Context) ([]Index, error) {
+	start := time.Now()
+	list, err := c.Collection.Indexes(ctx)
+	c.observe("indexes", nil, start, len(list), err)
+	return list, err
+}
+
+func (c *instrumentedCollection) CreateIndex(ctx context.Context, index Index) error {
+	start := time.Now()
+	err := c.Collection.CreateIndex(ctx, index)
+	c.observe("createIndex", nil, start, 0, err)
+	return err
+}
+
+func (c *instrumentedCollection) DropIndex(ctx context.Context, name string) error {
+	start := time.Now()
+	err := c.Collection.DropIndex(ctx, name)
+	c.observe("dropIndex", nil, start, 0, err)
+	return err
+}
+
+// instrumentedFeed reports LastChange and every run of Changes to OperationHook and the metrics.
+// Changes runs as long as the subscription, so it is never slow. Its docs are the changes delivered.
+type instrumentedFeed struct {
+	ChangeFeed
+	db        string
+	threshold time.Duration
+}
+
+func (f *instrumentedFeed) LastChange(ctx context.Context, collection string, capped bool) (string, error) {
+	start := time.Now()
+	token, err := f.ChangeFeed.LastChange(ctx, collection, capped)
+	c := &instrumentedCollection{db: f.db, name: collection, threshold: f.threshold}
+	c.observe("lastChange", nil, start, 0, err)
+	return token, err
+}
+
+func (f *instrumentedFeed) Changes(ctx context.Context, collection, token string, capped bool, ch chan<- Change) error {
+	start := time.Now()
+	in := make(chan Change)
+	errc := make(chan error, 1)
+	go func() { errc <- f.ChangeFeed.Changes(ctx, collection, token, capped, in) }()
+
+	docs := 0
+	for {
+		select {
+		case c := <-in:
+			select {
+			case ch <- c:
+				docs++
+			case <-ctx.Done():
+			}
+		case err := <-errc:
+			observed := err
+			if ctx.Err() != nil {
+				observed = nil // closed, no failure
+			}
+			c := &instrumentedCollection{db: f.db, name: collection}
+			c.observe("changes", nil, start, docs, observed)
+			return err
+		}
+	}
+}
+
+// DurationBuckets are the upper bounds in seconds of the histogram of operation durations
+var DurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// opMetrics are the metrics of one operation on one collection
+type opMetrics struct {
+	count, errors, slow, docs int64
+	sum                       float64
+	buckets                   []int64
+}
+
+type registry struct {
+	mutex sync.Mutex
+	ops   map[[3]string]*opMetrics // db, collection, op
+}
+
+var metrics = &registry{ops: map[[3]string]*opMetrics{}}
+
+func (r *registry) add(o Operation) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := [3]string{o.DB, o.Collection, o.Op}
+	m, ok := r.ops[key]
+	if !ok {
+		m = &opMetrics{buckets: make([]int64, len(DurationBuckets))}
+		r.ops[key] = m
+	}
+	m.count++
+	m.docs += int64(o.Docs)
+	if o.Err != nil && o.Err != ErrNotFound {
+		m.errors++
+	}
+	if o.Slow {
+		m.slow++
+	}
+	secs := o.Duration.Seconds()
+	m.sum += secs
+	for i, le := range DurationBuckets {
+		if i < len(m.buckets) && secs <= le {
+			m.buckets[i]++
+		}
+	}
+}
+
+// ResetMetrics clears the metrics of all operations, e.g. between tests
+func ResetMetrics() {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.ops = map[[3]string]*opMetrics{}
+}
+
+// WriteMetrics writes the metrics of all operations in the Prometheus text format
+func WriteMetrics(w io.Writer) error {
+	metrics.mutex.Lock()
+	keys := make([][3]string, 0, len(metrics.ops))
+	ops := make(map[[3]string]opMetrics, len(metrics.ops))
+	for key, m := range metrics.ops {
+		keys = append(keys, key)
+		cp := *m
+		cp.buckets = append([]int64{}, m.buckets...)
+		ops[key] = cp
+	}
+	metrics.mutex.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], "\x00") < strings.Join(keys[j][:], "\x00")
+	})
+
+	b := &strings.Builder{}
+	counter := func(name, help string, val func(opMetrics) int64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, key := range keys {
+			fmt.Fprintf(b, "%s{%s} %d\n", name, labels(key), val(ops[key]))
+		}
+	}
+	counter("crmgo_operations_total", "Operations on collections.", func(m opMetrics) int64 { return m.count })
+	counter("crmgo_operation_errors_total", "Failed operations on collections, not counting not found.", func(m opMetrics) int64 { return m.errors })
+	counter("crmgo_slow_operations_total", "Operations slower than the slow query threshold.", func(m opMetrics) int64 { return m.slow })
+	counter("crmgo_documents_total", "Documents returned, inserted, updated, deleted or counted.", func(m opMetrics) int64 { return m.docs })
+
+	name := "crmgo_operation_duration_seconds"
+	fmt.Fprintf(b, "# HELP %s Duration of operations on collections.\n# TYPE %s histogram\n", name, name)
+	for _, key := range keys {
+		m := ops[key]
+		for i, le := range DurationBuckets {
+			if i < len(m.buckets) {
+				fmt.Fprintf(b, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels(key), le, m.buckets[i])
+			}
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels(key), m.count)
+		fmt.Fprintf(b, "%s_sum{%s} %g\n", name, labels(key), m.sum)
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels(key), m.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func labels(key [3]string) string {
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return fmt.Sprintf(`db="%s",collection="%s",op="%s"`, esc.Replace(key[0]), esc.Replace(key[1]), esc.Replace(key[2]))
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+}
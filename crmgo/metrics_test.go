@@ -0,0 +1,160 @@
+package crmgo_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+type debugLog struct {
+	lines []string
+}
+
+func (l *debugLog) Debugln(args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintln(args...))
+}
+
+type warnLog struct {
+	debugLog
+	warnings []string
+}
+
+func (l *warnLog) Warnln(args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintln(args...))
+}
+
+func TestOperationHook(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	var ops []crmgo.Operation
+	defer func(hook func(crmgo.Operation)) { crmgo.OperationHook = hook }(crmgo.OperationHook)
+	crmgo.OperationHook = func(op crmgo.Operation) { ops = append(ops, op) }
+
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+
+	var found []member
+	test.Nil(family.Find(ctx, crmgo.Q{"name": "Mary"}.GT("age", 18).In("tags", "parent", "kid"), nil, &found))
+	_, err := family.Update(ctx, crmgo.Q{"_id": "tim"}, crmgo.U{}.Inc("age", 1), nil)
+	test.Nil(err)
+	test.Equal(crmgo.ErrNotFound, family.FindOne(ctx, crmgo.Q{"_id": "bob"}, nil, &member{}))
+
+	if !test.Len(ops, 4) {
+		return
+	}
+	test.Equal("insert", ops[0].Op)
+	test.Equal(4, ops[0].Docs)
+	test.Equal("family", ops[0].Collection)
+	test.Equal("test_TestOperationHook", ops[0].DB)
+
+	test.Equal("find", ops[1].Op)
+	test.Equal(1, ops[1].Docs)
+	test.Equal(`{"age":{"$gt":"?"},"name":"?","tags":{"$in":["?","?"]}}`, ops[1].Filter())
+	test.NotContains(ops[1].String(), "Mary")
+
+	test.Equal("update", ops[2].Op)
+	test.Equal(1, ops[2].Docs)
+	test.Equal(`{"_id":"?"}`, ops[2].Filter())
+
+	test.Equal("findOne", ops[3].Op)
+	test.Equal(0, ops[3].Docs)
+	test.Equal(crmgo.ErrNotFound, ops[3].Err)
+	test.False(ops[3].Slow)
+}
+
+func TestOperationHookIndexesAndChanges(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	ops := map[string]crmgo.Operation{}
+	defer func(hook func(crmgo.Operation)) { crmgo.OperationHook = hook }(crmgo.OperationHook)
+	crmgo.OperationHook = func(op crmgo.Operation) {
+		mutex.Lock()
+		ops[op.Op] = op
+		mutex.Unlock()
+	}
+
+	db := openMemory(t)
+	family := db.Collection("family")
+	test.Nil(family.CreateIndex(ctx, crmgo.Index{Key: []string{"name"}}))
+	_, err := family.Indexes(ctx)
+	test.Nil(err)
+	test.Nil(family.DropIndex(ctx, "name_1"))
+
+	sub, err := db.Subscribe(ctx, "consumer", "family", nil)
+	if !test.Nil(err) {
+		return
+	}
+	test.Nil(family.Insert(ctx, &member{ID: "bob"}))
+	receive(t, sub)
+	sub.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	test.Equal(2, ops["indexes"].Docs)
+	test.Contains(ops, "createIndex")
+	test.Contains(ops, "dropIndex")
+	test.Contains(ops, "lastChange")
+	test.Equal(1, ops["changes"].Docs)
+	test.Nil(ops["changes"].Err)
+}
+
+func TestSlowQueries(t *testing.T) {
+	test := assert.New(t)
+
+	log := &warnLog{}
+	defer func(logger crmgo.DBLogger) { crmgo.Logger = logger }(crmgo.Logger)
+	crmgo.Logger = log
+	t.Setenv("MONGO_SLOW_QUERY_THRESHOLD", "1ns")
+
+	family := openMemory(t).Collection("family")
+	_, err := family.Count(context.Background(), crmgo.Q{"name": "Mary"})
+	test.Nil(err)
+
+	if test.Len(log.warnings, 1) {
+		test.Contains(log.warnings[0], "slow mongo count test_TestSlowQueries.family")
+		test.NotContains(log.warnings[0], "Mary")
+	}
+	test.Empty(log.lines) // logged once
+
+	// without Warnln by Debugln, once as well
+	debug := &debugLog{}
+	crmgo.Logger = debug
+	_, err = family.Count(context.Background(), crmgo.Q{"name": "Mary"})
+	test.Nil(err)
+	if test.Len(debug.lines, 1) {
+		test.Contains(debug.lines[0], "slow mongo count test_TestSlowQueries.family")
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	crmgo.ResetMetrics()
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+	var found []member
+	test.Nil(family.Find(ctx, nil, nil, &found))
+	test.Nil(family.Find(ctx, crmgo.Q{}.LT("age", 18), nil, &found))
+	test.NotNil(family.Insert(ctx, &member{ID: "tim"}))
+
+	b := &strings.Builder{}
+	test.Nil(crmgo.WriteMetrics(b))
+	out := b.String()
+
+	labels := `db="test_TestWriteMetrics",collection="family",op="find"`
+	test.Contains(out, "# TYPE crmgo_operations_total counter\n")
+	test.Contains(out, "crmgo_operations_total{"+labels+"} 2\n")
+	test.Contains(out, "crmgo_documents_total{"+labels+"} 6\n")
+	test.Contains(out, "crmgo_operation_duration_seconds_bucket{"+labels+",le=\"+Inf\"} 2\n")
+	test.Contains(out, "crmgo_operation_duration_seconds_count{"+labels+"} 2\n")
+	test.Contains(out, `crmgo_operation_errors_total{db="test_TestWriteMetrics",collection="family",op="insert"} 1`+"\n")
+}
@@ -0,0 +1,578 @@
+package crmgo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mgoDriver is the Driver using gopkg.in/mgo.v2
+type mgoDriver struct {
+	session *mgo.Session
+	dbName  string
+}
+
+type mgoCollection struct {
+	driver *mgoDriver
+	name   string
+}
+
+func init() {
+	RegisterDriver("mgo", openMgo)
+}
+
+func openMgo(ctx context.Context, suffix, dbname string) (Driver, error) {
+	m := &Multi{suffix}
+	cfg, err := m.dialConfig(dbname)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < cfg.info.Timeout {
+		cfg.info.Timeout = time.Until(deadline)
+	}
+
+	Logger.Debugln("dialup database", cfg)
+	type dialed struct {
+		sess *mgo.Session
+		err  error
+	}
+	res := make(chan dialed, 1)
+	go func() {
+		sess, err := mgo.DialWithInfo(cfg.info)
+		if err == nil {
+			if err = sess.Ping(); err != nil {
+				sess.Close()
+				sess = nil
+			}
+		}
+		res <- dialed{sess, err}
+	}()
+
+	var d dialed
+	select {
+	case d = <-res:
+	case <-ctx.Done():
+		go func() {
+			if d := <-res; d.sess != nil {
+				d.sess.Close() // nobody is waiting for it anymore
+			}
+		}()
+		return nil, ctx.Err()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	sess := d.sess
+	sess.SetMode(cfg.mode, true)
+	sess.SetSocketTimeout(cfg.socketTimeout)
+	if cfg.poolTimeout > 0 {
+		sess.SetSyncTimeout(cfg.poolTimeout)
+	}
+	return &mgoDriver{session: sess, dbName: dbname}, nil
+}
+
+// withContext runs fn, but returns as soon as ctx is done, leaving fn to finish in the background.
+// So fn must not write to variables of the caller, its result is returned only if it finished in time.
+// The socket timeout and max time set by the deadline of ctx make sure fn ends as well.
+func withContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	if ctx.Done() == nil {
+		return fn()
+	}
+	type result struct {
+		val T
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		val, err := fn()
+		res <- result{val, err}
+	}()
+	select {
+	case r := <-res:
+		return r.val, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// decodeRaw decodes the documents into result, which must be a pointer to a slice
+func decodeRaw(docs []bson.Raw, result interface{}) error {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("result must be a pointer to a slice")
+	}
+	slice := reflect.MakeSlice(rv.Elem().Type(), len(docs), len(docs))
+	for i, doc := range docs {
+		if err := doc.Unmarshal(slice.Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// session returns the session to use for ctx and a func to release it.
+// With a deadline it is a copy with a socket timeout until the deadline.
+func (d *mgoDriver) sessionFor(ctx context.Context) (*mgo.Session, func()) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return d.session, func() {}
+	}
+	sess := d.session.Copy()
+	sess.SetSocketTimeout(time.Until(deadline))
+	return sess, sess.Close
+}
+
+// CContext returns the mgo collection for operations with the deadline of ctx and a func to release it
+func (d *mgoDriver) CContext(ctx context.Context, name string) (*mgo.Collection, func()) {
+	sess, release := d.sessionFor(ctx)
+	return sess.DB(d.dbName).C(name), release
+}
+
+// C returns the mgo collection
+func (d *mgoDriver) C(name string) *mgo.Collection {
+	return d.session.DB(d.dbName).C(name)
+}
+
+// Reconnect refreshes the session, so broken sockets are replaced
+func (d *mgoDriver) Reconnect() {
+	d.session.Refresh()
+	Logger.Debugln("refreshed mongo session")
+}
+
+func (d *mgoDriver) Collection(name string) Collection {
+	return &mgoCollection{driver: d, name: name}
+}
+
+func (d *mgoDriver) Ping(ctx context.Context) error {
+	sess, release := d.sessionFor(ctx)
+	_, err := withContext(ctx, func() (struct{}, error) {
+		defer release()
+		return struct{}{}, sess.Ping()
+	})
+	return err
+}
+
+func (d *mgoDriver) Drop(ctx context.Context) error {
+	d.session.Refresh()
+	sess, release := d.sessionFor(ctx)
+	_, err := withContext(ctx, func() (struct{}, error) {
+		defer release()
+		return struct{}{}, sess.DB(d.dbName).DropDatabase()
+	})
+	return err
+}
+
+func (d *mgoDriver) Close() {
+	d.session.Close()
+}
+
+// run runs fn on the collection, until ctx is done
+func (c *mgoCollection) run(ctx context.Context, fn func(*mgo.Collection) error) error {
+	_, err := runResult(ctx, c, func(coll *mgo.Collection) (struct{}, error) {
+		return struct{}{}, fn(coll)
+	})
+	return err
+}
+
+// runResult runs fn on the collection like run and returns its result.
+// fn may finish in the background after ctx is done, so it must not write to variables of the caller.
+func runResult[T any](ctx context.Context, c *mgoCollection, fn func(*mgo.Collection) (T, error)) (T, error) {
+	coll, release := c.driver.CContext(ctx, c.name)
+	val, err := withContext(ctx, func() (T, error) {
+		defer release()
+		val, err := fn(coll)
+		if isNetworkErr(err) {
+			c.driver.Reconnect() // don't wait for the health check to replace the socket
+		}
+		return val, err
+	})
+	if ctx.Err() != nil && err != nil {
+		return val, ctx.Err() // timeouts of the socket or server by the deadline
+	}
+	return val, mgoErr(err)
+}
+
+func isNetworkErr(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && !ne.Timeout()
+}
+
+func (c *mgoCollection) Name() string {
+	return c.name
+}
+
+func (c *mgoCollection) query(ctx context.Context, coll *mgo.Collection, filter interface{}, opts *FindOptions) *mgo.Query {
+	q := coll.Find(filter)
+	if deadline, ok := ctx.Deadline(); ok {
+		q = q.SetMaxTime(time.Until(deadline)) // stops the query on the server as well
+	}
+	if opts == nil {
+		return q
+	}
+	if len(opts.Sort) > 0 {
+		q = q.Sort(opts.Sort...)
+	}
+	if opts.Skip > 0 {
+		q = q.Skip(opts.Skip)
+	}
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit)
+	}
+	if opts.Projection != nil {
+		q = q.Select(opts.Projection)
+	}
+	return q
+}
+
+// Find reads the raw documents and decodes them after the query is done, see runResult
+func (c *mgoCollection) Find(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	docs, err := runResult(ctx, c, func(coll *mgo.Collection) (docs []bson.Raw, err error) {
+		err = c.query(ctx, coll, filter, opts).All(&docs)
+		return docs, err
+	})
+	if err != nil {
+		return err
+	}
+	return decodeRaw(docs, result)
+}
+
+func (c *mgoCollection) FindOne(ctx context.Context, filter interface{}, opts *FindOptions, result interface{}) error {
+	doc, err := runResult(ctx, c, func(coll *mgo.Collection) (doc bson.Raw, err error) {
+		err = c.query(ctx, coll, filter, opts).One(&doc)
+		return doc, err
+	})
+	if err != nil || result == nil {
+		return err
+	}
+	return doc.Unmarshal(result)
+}
+
+func (c *mgoCollection) Insert(ctx context.Context, docs ...interface{}) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.Insert(docs...)
+	})
+}
+
+func (c *mgoCollection) Update(ctx context.Context, filter, update interface{}, opts *UpdateOptions) (*UpdateResult, error) {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	res, err := runResult(ctx, c, func(coll *mgo.Collection) (*UpdateResult, error) {
+		res := &UpdateResult{}
+		var info *mgo.ChangeInfo
+		var err error
+		switch {
+		case opts.Upsert:
+			info, err = coll.Upsert(filter, update)
+		case opts.Multi:
+			info, err = coll.UpdateAll(filter, update)
+		default:
+			if err = coll.Update(filter, update); err == nil {
+				info = &mgo.ChangeInfo{Matched: 1, Updated: 1}
+			}
+		}
+		if info != nil {
+			res.Matched, res.Updated, res.UpsertedID = info.Matched, info.Updated, info.UpsertedId
+		}
+		return res, err
+	})
+	if res == nil {
+		res = &UpdateResult{}
+	}
+	if err == ErrNotFound {
+		return res, nil // nothing matched
+	}
+	return res, err
+}
+
+func (c *mgoCollection) Delete(ctx context.Context, filter interface{}, multi bool) (int, error) {
+	n, err := runResult(ctx, c, func(coll *mgo.Collection) (int, error) {
+		if multi {
+			info, err := coll.RemoveAll(filter)
+			if info != nil {
+				return info.Removed, err
+			}
+			return 0, err
+		}
+		if err := coll.Remove(filter); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (c *mgoCollection) Aggregate(ctx context.Context, pipeline interface{}, result interface{}) error {
+	docs, err := runResult(ctx, c, func(coll *mgo.Collection) (docs []bson.Raw, err error) {
+		err = coll.Pipe(pipeline).All(&docs)
+		return docs, err
+	})
+	if err != nil {
+		return err
+	}
+	return decodeRaw(docs, result)
+}
+
+func (c *mgoCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	return runResult(ctx, c, func(coll *mgo.Collection) (int, error) {
+		return coll.Find(filter).Count()
+	})
+}
+
+func (c *mgoCollection) Indexes(ctx context.Context) ([]Index, error) {
+	return runResult(ctx, c, func(coll *mgo.Collection) ([]Index, error) {
+		indexes, err := coll.Indexes()
+		if qe, ok := err.(*mgo.QueryError); ok && qe.Code == 26 {
+			return nil, nil // the collection doesn't exist yet
+		}
+		var list []Index
+		for _, i := range indexes {
+			list = append(list, Index{Name: i.Name, Key: i.Key, Unique: i.Unique, Sparse: i.Sparse, ExpireAfter: i.ExpireAfter})
+		}
+		return list, err
+	})
+}
+
+func (c *mgoCollection) CreateIndex(ctx context.Context, index Index) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.EnsureIndex(mgo.Index{
+			Name:        index.Name,
+			Key:         index.Key,
+			Unique:      index.Unique,
+			Sparse:      index.Sparse,
+			ExpireAfter: index.ExpireAfter,
+		})
+	})
+}
+
+func (c *mgoCollection) DropIndex(ctx context.Context, name string) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.DropIndexName(name)
+	})
+}
+
+// mgoErr maps mgo errors to the ones of crmgo
+func mgoErr(err error) error {
+	switch {
+	case err == mgo.ErrNotFound:
+		return ErrNotFound
+	case mgo.IsDup(err):
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+// feed returns the oplog, which needs a replica set, or the capped collection
+func (d *mgoDriver) feed(sess *mgo.Session, collection string, capped bool) *mgo.Collection {
+	if capped {
+		return sess.DB(d.dbName).C(collection)
+	}
+	return sess.DB("local").C("oplog.rs")
+}
+
+// feedQuery selects the entries of the feed after the _id or timestamp.
+// logReplay tells whether the oplog query can use LogReplay, which needs a lower bound of ts.
+func (d *mgoDriver) feedQuery(collection string, capped bool, after interface{}) (query bson.M, logReplay bool) {
+	key, query := "_id", bson.M{}
+	if !capped {
+		key = "ts"
+		query = bson.M{"ns": d.dbName + "." + collection, "op": bson.M{"$in": []string{"i", "u", "d"}}}
+	}
+	if after != nil {
+		query[key] = bson.M{"$gt": after}
+	}
+	return query, !capped && after != nil
+}
+
+// token makes the resume token of an entry of the feed,
+// the timestamp of oplog entries or the _id of documents in capped collections
+func (d *mgoDriver) token(entry bson.M, capped bool) (string, error) {
+	if !capped {
+		ts, ok := entry["ts"].(bson.MongoTimestamp)
+		if !ok {
+			return "", ErrInvalidToken
+		}
+		return strconv.FormatInt(int64(ts), 10), nil
+	}
+	data, err := bson.Marshal(bson.M{"id": entry["_id"]})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// after returns the timestamp or _id of a resume token
+func (d *mgoDriver) after(token string, capped bool) (interface{}, error) {
+	if token == "" {
+		return nil, nil
+	}
+	if !capped {
+		ts, err := strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+		return bson.MongoTimestamp(ts), nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	var id struct {
+		ID interface{} `bson:"id"`
+	}
+	if err != nil || bson.Unmarshal(data, &id) != nil {
+		return nil, ErrInvalidToken
+	}
+	return id.ID, nil
+}
+
+// checkToken returns ErrInvalidToken, if the feed doesn't reach back to the entry of a token anymore,
+// as the oplog starts after its timestamp or the document was removed from the capped collection.
+// Changes after it may be lost then.
+func (d *mgoDriver) checkToken(feed *mgo.Collection, capped bool, after interface{}) error {
+	if after == nil {
+		return nil
+	}
+	if capped {
+		n, err := feed.FindId(after).Count()
+		if err != nil {
+			return mgoErr(err)
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: document %v is gone from the capped collection", ErrInvalidToken, after)
+		}
+		return nil
+	}
+
+	var first struct {
+		TS bson.MongoTimestamp `bson:"ts"`
+	}
+	err := feed.Find(nil).Sort("$natural").Select(bson.M{"ts": 1}).One(&first)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return mgoErr(err)
+	}
+	if ts, _ := after.(bson.MongoTimestamp); ts < first.TS {
+		return fmt.Errorf("%w: the oplog starts after it", ErrInvalidToken)
+	}
+	return nil
+}
+
+// change converts an entry of the feed
+func (d *mgoDriver) change(entry bson.M, collection string, capped bool) (Change, error) {
+	token, err := d.token(entry, capped)
+	if err != nil {
+		return Change{}, err
+	}
+	c := Change{Collection: collection, Token: token}
+	if capped {
+		c.Op, c.ID, c.Doc = "insert", entry["_id"], plainDoc(entry)
+		return c, nil
+	}
+
+	o, _ := entry["o"].(bson.M)
+	switch entry["op"] {
+	case "i":
+		c.Op, c.ID, c.Doc = "insert", o["_id"], plainDoc(o)
+	case "u":
+		o2, _ := entry["o2"].(bson.M)
+		c.Op, c.ID, c.Update = "update", o2["_id"], plainDoc(o)
+	case "d":
+		c.Op, c.ID = "delete", o["_id"]
+	}
+	return c, nil
+}
+
+// LastChange returns the token of the last entry of the oplog or capped collection
+func (d *mgoDriver) LastChange(ctx context.Context, collection string, capped bool) (string, error) {
+	sess, release := d.sessionFor(ctx)
+	token, err := withContext(ctx, func() (string, error) {
+		defer release()
+		var last bson.M
+		err := d.feed(sess, collection, capped).Find(nil).Sort("-$natural").One(&last)
+		if err == mgo.ErrNotFound {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return d.token(last, capped)
+	})
+	return token, mgoErr(err)
+}
+
+// Changes tails the oplog or capped collection
+func (d *mgoDriver) Changes(ctx context.Context, collection, token string, capped bool, ch chan<- Change) error {
+	after, err := d.after(token, capped)
+	if err != nil {
+		return err
+	}
+	sess := d.session.Copy()
+	defer sess.Close()
+	feed := d.feed(sess, collection, capped)
+	if err := d.checkToken(feed, capped, after); err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		q, logReplay := d.feedQuery(collection, capped, after)
+		query := feed.Find(q).Sort("$natural")
+		if logReplay {
+			query = query.LogReplay()
+		}
+		// wake up every second to check ctx
+		iter := query.Tail(time.Second)
+		for ctx.Err() == nil {
+			var entry bson.M
+			if !iter.Next(&entry) {
+				if iter.Timeout() {
+					continue
+				}
+				break
+			}
+			c, err := d.change(entry, collection, capped)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			select {
+			case ch <- c:
+				after = entry["_id"]
+				if !capped {
+					after = entry["ts"]
+				}
+			case <-ctx.Done():
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return mgoErr(err)
+		}
+
+		// the cursor died, e.g. as the capped collection was empty
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,338 @@
+package crmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Migration is a versioned change of a database, like creating an index or transforming documents
+type Migration struct {
+	// ID identifies the migration, migrations run ordered by it, e.g. "20260101_email_index"
+	ID string
+	// Description tells what it does
+	Description string
+	// Up applies the migration
+	Up func(ctx context.Context, db *DB) error
+	// Down reverts it, may be nil if it can't be reverted
+	Down func(ctx context.Context, db *DB) error
+}
+
+// MigrationStatus tells whether a migration is applied
+type MigrationStatus struct {
+	ID          string
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// ErrMigrationLocked is returned if migrations are run somewhere else at the moment
+var ErrMigrationLocked = errors.New("migrations are locked by another runner")
+
+var (
+	// MigrationsCollection keeps the applied migrations, a lock is kept in it with the suffix "_lock"
+	MigrationsCollection = "migrations"
+	// MigrationLockTimeout is the time after which a lock is considered stale, e.g. of a crashed runner
+	MigrationLockTimeout = 30 * time.Minute
+
+	migrationMutex sync.Mutex
+	migrations     = map[string]Migration{}
+)
+
+type appliedMigration struct {
+	ID          string    `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+type migrationLock struct {
+	ID    string    `bson:"_id"`
+	Owner string    `bson:"owner"`
+	Since time.Time `bson:"since"`
+}
+
+// RegisterMigration registers a migration, usually in an init function.
+// It panics, if the ID is registered already.
+func RegisterMigration(m Migration) {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+	if m.ID == "" || m.Up == nil {
+		panic("crmgo: migration needs an ID and Up")
+	}
+	if _, ok := migrations[m.ID]; ok {
+		panic("crmgo: migration " + m.ID + " registered twice")
+	}
+	migrations[m.ID] = m
+}
+
+// registeredMigrations returns the migrations ordered by ID
+func registeredMigrations() []Migration {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+	list := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// MigrateUp applies all registered migrations not applied yet and returns their IDs.
+// It stops at the first failing migration.
+func MigrateUp(ctx context.Context, db *DB) ([]string, error) {
+	var done []string
+	err := withMigrationLock(ctx, db, func(applied map[string]appliedMigration) error {
+		for _, m := range registeredMigrations() {
+			if _, ok := applied[m.ID]; ok {
+				continue
+			}
+			Logger.Debugln("migrate up", m.ID)
+			if err := m.Up(ctx, db); err != nil {
+				return fmt.Errorf("migration %s: %w", m.ID, err)
+			}
+			doc := appliedMigration{ID: m.ID, Description: m.Description, AppliedAt: time.Now()}
+			if err := db.Collection(MigrationsCollection).Insert(ctx, &doc); err != nil {
+				return err
+			}
+			done = append(done, m.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown reverts the last steps applied migrations and returns their IDs
+func MigrateDown(ctx context.Context, db *DB, steps int) ([]string, error) {
+	var done []string
+	err := withMigrationLock(ctx, db, func(applied map[string]appliedMigration) error {
+		ids := make([]string, 0, len(applied))
+		for id := range applied {
+			ids = append(ids, id)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+		for _, id := range ids {
+			if len(done) >= steps {
+				break
+			}
+			migrationMutex.Lock()
+			m, ok := migrations[id]
+			migrationMutex.Unlock()
+			switch {
+			case !ok:
+				return fmt.Errorf("migration %s is applied, but not registered", id)
+			case m.Down == nil:
+				return fmt.Errorf("migration %s can't be reverted", id)
+			}
+
+			Logger.Debugln("migrate down", m.ID)
+			if err := m.Down(ctx, db); err != nil {
+				return fmt.Errorf("migration %s: %w", m.ID, err)
+			}
+			if _, err := db.Collection(MigrationsCollection).Delete(ctx, Q{"_id": m.ID}, false); err != nil {
+				return err
+			}
+			done = append(done, m.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrationStatuses returns the status of all registered migrations
+func MigrationStatuses(ctx context.Context, db *DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var list []MigrationStatus
+	for _, m := range registeredMigrations() {
+		a, ok := applied[m.ID]
+		list = append(list, MigrationStatus{ID: m.ID, Description: m.Description, Applied: ok, AppliedAt: a.AppliedAt})
+	}
+	return list, nil
+}
+
+func appliedMigrations(ctx context.Context, db *DB) (map[string]appliedMigration, error) {
+	var list []appliedMigration
+	if err := db.Collection(MigrationsCollection).Find(ctx, nil, nil, &list); err != nil {
+		return nil, err
+	}
+	applied := make(map[string]appliedMigration, len(list))
+	for _, a := range list {
+		applied[a.ID] = a
+	}
+	return applied, nil
+}
+
+// withMigrationLock runs fn holding the lock, so only one runner migrates at a time.
+// The lock is refreshed while fn runs, so it doesn't look stale to other runners.
+func withMigrationLock(ctx context.Context, db *DB, fn func(map[string]appliedMigration) error) error {
+	locks := db.Collection(MigrationsCollection + "_lock")
+	host, _ := os.Hostname()
+	lock := migrationLock{ID: "lock", Owner: host + ":" + strconv.Itoa(os.Getpid()) + ":" + strconv.FormatInt(time.Now().UnixNano(), 36), Since: time.Now()}
+
+	err := locks.Insert(ctx, &lock)
+	if err == ErrDuplicateKey {
+		err = takeOverLock(ctx, locks, lock)
+	}
+	if err != nil {
+		return err
+	}
+	defer locks.Delete(context.Background(), Q{"_id": lock.ID, "owner": lock.Owner}, false)
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go refreshLock(locks, lock, stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	return fn(applied)
+}
+
+// refreshLock updates the since of the lock every third of MigrationLockTimeout until stop is closed
+func refreshLock(locks Collection, lock migrationLock, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(MigrationLockTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		res, err := locks.Update(context.Background(), Q{"_id": lock.ID, "owner": lock.Owner}, U{}.Set("since", time.Now()), nil)
+		switch {
+		case err != nil:
+			warn("refreshing migration lock failed:", err)
+		case res.Matched == 0:
+			warn("migration lock of", lock.Owner, "was taken over")
+		}
+	}
+}
+
+// takeOverLock takes over a stale lock of a runner, which didn't release it
+func takeOverLock(ctx context.Context, locks Collection, lock migrationLock) error {
+	var cur migrationLock
+	err := locks.FindOne(ctx, Q{"_id": lock.ID}, nil, &cur)
+	if err == ErrNotFound {
+		return locks.Insert(ctx, &lock) // released meanwhile
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(cur.Since) < MigrationLockTimeout {
+		return fmt.Errorf("%w %s since %s", ErrMigrationLocked, cur.Owner, cur.Since.Format(time.RFC3339))
+	}
+
+	Logger.Debugln("taking over stale migration lock of", cur.Owner)
+	res, err := locks.Update(ctx, Q{"_id": lock.ID, "owner": cur.Owner}, U{}.Set("owner", lock.Owner).Set("since", lock.Since), nil)
+	if err != nil {
+		return err
+	}
+	if res.Matched == 0 {
+		return ErrMigrationLocked // someone else was faster
+	}
+	return nil
+}
+
+// MigrateCommand runs the registered migrations as a cmd.Command.
+// It takes the arguments after the command name:
+// "up", "down [steps]" (default 1 step) or "status".
+type MigrateCommand struct {
+	// DBName is the database to migrate
+	DBName string
+	// Suffix selects the configuration like WithSuffix
+	Suffix string
+	// Out gets the output, os.Stdout if nil
+	Out io.Writer
+
+	db  *DB
+	err error
+}
+
+// Init opens the database
+func (c *MigrateCommand) Init() {
+	if c.Out == nil {
+		c.Out = os.Stdout
+	}
+	c.db, c.err = WithSuffix(c.Suffix).Open(c.DBName)
+}
+
+// Start runs the migrations as told by the arguments
+func (c *MigrateCommand) Start() error {
+	if c.err != nil {
+		return c.err
+	}
+	ctx := context.Background()
+
+	args := []string{}
+	if len(os.Args) > 2 {
+		args = os.Args[2:]
+	}
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := MigrateUp(ctx, c.db)
+		c.report("applied", done)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps %s", args[1])
+			}
+			steps = n
+		}
+		done, err := MigrateDown(ctx, c.db, steps)
+		c.report("reverted", done)
+		return err
+	case "status":
+		list, err := MigrationStatuses(ctx, c.db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(c.Out, 0, 4, 2, ' ', 0)
+		for _, s := range list {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.ID, applied, s.Description)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate argument %s, use up, down or status", args[0])
+}
+
+// Clean closes the database
+func (c *MigrateCommand) Clean() {
+	if c.db != nil {
+		c.db.Close()
+	}
+}
+
+func (c *MigrateCommand) report(what string, ids []string) {
+	if len(ids) == 0 {
+		fmt.Fprintln(c.Out, "nothing", what)
+	}
+	for _, id := range ids {
+		fmt.Fprintln(c.Out, what, id)
+	}
+}
@@ -0,0 +1,109 @@
+package crmgo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	for _, id := range []string{"001_first", "002_second", "003_third"} {
+		id := id
+		crmgo.RegisterMigration(crmgo.Migration{
+			ID:          id,
+			Description: "adds " + id,
+			Up: func(ctx context.Context, db *crmgo.DB) error {
+				return db.Collection("things").Insert(ctx, crmgo.Q{"_id": id})
+			},
+			Down: func(ctx context.Context, db *crmgo.DB) error {
+				_, err := db.Collection("things").Delete(ctx, crmgo.Q{"_id": id}, false)
+				return err
+			},
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+	db := openMemory(t)
+
+	done, err := crmgo.MigrateUp(ctx, db)
+	test.Nil(err)
+	test.Equal([]string{"001_first", "002_second", "003_third"}, done)
+	done, err = crmgo.MigrateUp(ctx, db)
+	test.Nil(err)
+	test.Empty(done)
+
+	done, err = crmgo.MigrateDown(ctx, db, 2)
+	test.Nil(err)
+	test.Equal([]string{"003_third", "002_second"}, done)
+	n, _ := db.Collection("things").Count(ctx, nil)
+	test.Equal(1, n)
+
+	list, err := crmgo.MigrationStatuses(ctx, db)
+	test.Nil(err)
+	if test.Len(list, 3) {
+		test.True(list[0].Applied)
+		test.False(list[1].Applied)
+		test.Equal("adds 002_second", list[1].Description)
+	}
+
+	locks := db.Collection(crmgo.MigrationsCollection + "_lock")
+	test.Nil(locks.Insert(ctx, crmgo.Q{"_id": "lock", "owner": "other", "since": time.Now()}))
+	_, err = crmgo.MigrateUp(ctx, db)
+	test.True(errors.Is(err, crmgo.ErrMigrationLocked))
+
+	_, err = locks.Update(ctx, crmgo.Q{"_id": "lock"}, crmgo.U{}.Set("since", time.Now().Add(-time.Hour)), nil)
+	test.Nil(err)
+	done, err = crmgo.MigrateUp(ctx, db)
+	test.Nil(err)
+	test.Len(done, 2)
+	n, _ = locks.Count(ctx, nil)
+	test.Equal(0, n)
+}
+
+func TestMigrateCommand(t *testing.T) {
+	test := assert.New(t)
+	openMemory(t)
+	args := os.Args
+	t.Cleanup(func() { os.Args = args })
+
+	run := func(args ...string) (string, error) {
+		os.Args = append([]string{"app", "migrate"}, args...)
+		out := &bytes.Buffer{}
+		c := &crmgo.MigrateCommand{DBName: "test_" + t.Name(), Out: out}
+		c.Init()
+		err := c.Start()
+		c.Clean()
+		return out.String(), err
+	}
+
+	out, err := run("up")
+	test.Nil(err)
+	test.Equal("applied 001_first\napplied 002_second\napplied 003_third\n", out)
+
+	out, err = run("down")
+	test.Nil(err)
+	test.Equal("reverted 003_third\n", out)
+
+	out, err = run("status")
+	test.Nil(err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if test.Len(lines, 3) {
+		test.Contains(lines[0], "001_first")
+		test.Contains(lines[2], "pending")
+	}
+
+	_, err = run("sideways")
+	test.NotNil(err)
+	_, err = run("down", "zero")
+	test.EqualError(err, "invalid steps zero")
+}
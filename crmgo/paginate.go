@@ -0,0 +1,161 @@
+package crmgo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrInvalidCursor is returned for cursors not made by Paginate with the same sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageOptions select a page for Paginate
+type PageOptions struct {
+	// Sort by these fields, prefix a field by '-' for descending order.
+	// _id is added as the last one, if missing, to make the order unique.
+	Sort []string
+	// Limit is the size of a page
+	Limit int
+	// Cursor continues after the previous page, empty for the first one
+	Cursor string
+}
+
+// cursor is the content of a continuation token
+type cursor struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+}
+
+// Paginate finds a page of the documents matching filter into result, which must be a pointer to a slice.
+// Instead of skipping documents it continues after the sort values of the last document of the previous page,
+// so deep pages are as fast as the first one. The sort fields must exist in all documents,
+// otherwise building the cursor fails.
+// It returns the cursor for the next page, or "" if there are no more documents.
+func Paginate(ctx context.Context, coll Collection, filter Q, opts PageOptions, result interface{}) (string, error) {
+	sort := append([]string{}, opts.Sort...)
+	hasID := false
+	for _, field := range sort {
+		hasID = hasID || strings.TrimLeft(field, "+-") == "_id"
+	}
+	if !hasID {
+		sort = append(sort, "_id")
+	}
+
+	if opts.Cursor != "" {
+		after, err := keyset(sort, opts.Cursor)
+		if err != nil {
+			return "", err
+		}
+		if len(filter) > 0 {
+			after = And(filter, after)
+		}
+		filter = after
+	}
+
+	find := &FindOptions{Sort: sort}
+	if opts.Limit > 0 {
+		find.Limit = opts.Limit + 1 // one more tells whether there is a next page
+	}
+	var docs []bson.M
+	if err := coll.Find(ctx, filter, find, &docs); err != nil {
+		return "", err
+	}
+
+	next := ""
+	if opts.Limit > 0 && len(docs) > opts.Limit {
+		docs = docs[:opts.Limit]
+		c := cursor{Sort: strings.Join(sort, ",")}
+		for _, field := range sort {
+			field = strings.TrimLeft(field, "+-")
+			val, found := lookup(docs[len(docs)-1], field)
+			if !found {
+				return "", fmt.Errorf("paginate: sort field %s missing in document %v", field, docs[len(docs)-1]["_id"])
+			}
+			c.Values = append(c.Values, val)
+		}
+		data, err := bson.Marshal(c)
+		if err != nil {
+			return "", err
+		}
+		next = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return next, decodeAll(docs, result)
+}
+
+// keyset returns the filter for the documents after the cursor, like
+// {"$or": [{"a": {"$gt": 1}}, {"a": 1, "_id": {"$gt": 2}}]} for the sort a,_id
+func keyset(sort []string, token string) (Q, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err = bson.Unmarshal(data, &c); err != nil || c.Sort != strings.Join(sort, ",") || len(c.Values) != len(sort) {
+		return nil, ErrInvalidCursor
+	}
+	// cursors come from clients, values must not inject operators into the filter
+	for _, val := range c.Values {
+		if hasOperator(val) {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	or := make([]Q, len(sort))
+	for i, field := range sort {
+		q := Q{}
+		for j := 0; j < i; j++ {
+			q[strings.TrimLeft(sort[j], "+-")] = c.Values[j]
+		}
+		op := "$gt"
+		if strings.HasPrefix(field, "-") {
+			op = "$lt"
+		}
+		q[strings.TrimLeft(field, "+-")] = Q{op: c.Values[i]}
+		or[i] = q
+	}
+	return Or(or...), nil
+}
+
+// hasOperator tells whether val is or contains a document with a key starting with '$'
+func hasOperator(val interface{}) bool {
+	switch v := val.(type) {
+	case bson.M:
+		for key, elem := range v {
+			if strings.HasPrefix(key, "$") || hasOperator(elem) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if hasOperator(elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Page finds a page of the documents matching q and returns the cursor for the next page, or "" if there is none.
+// Fields to sort by are resolved like in queries.
+func (r *Repository[T]) Page(ctx context.Context, q Q, opts PageOptions) ([]T, string, error) {
+	filter, err := r.query(q)
+	if err != nil {
+		return nil, "", err
+	}
+	find, err := r.findOptions(&FindOptions{Sort: opts.Sort})
+	if err != nil {
+		return nil, "", err
+	}
+	opts.Sort = find.Sort
+
+	var docs []T
+	next, err := Paginate(ctx, r.coll, filter, opts, &docs)
+	if err != nil {
+		return nil, "", err
+	}
+	return docs, next, nil
+}
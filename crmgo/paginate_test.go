@@ -0,0 +1,68 @@
+package crmgo_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPaginate(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	db := openMemory(t)
+	family := db.Collection("family")
+	for i := 0; i < 10; i++ {
+		test.Nil(family.Insert(ctx, &member{ID: fmt.Sprintf("m%02d", i), Name: "Member", Age: i % 3}))
+	}
+
+	var ids []string
+	opts := crmgo.PageOptions{Sort: []string{"-age"}, Limit: 4}
+	for pages := 0; pages < 5; pages++ {
+		var page []member
+		next, err := crmgo.Paginate(ctx, family, crmgo.Q{"name": "Member"}, opts, &page)
+		test.Nil(err)
+		for _, m := range page {
+			ids = append(ids, m.ID)
+		}
+		if next == "" {
+			test.Len(page, 2)
+			break
+		}
+		test.Len(page, 4)
+		opts.Cursor = next
+	}
+	test.Equal([]string{"m02", "m05", "m08", "m01", "m04", "m07", "m00", "m03", "m06", "m09"}, ids)
+
+	var page []member
+	_, err := crmgo.Paginate(ctx, family, nil, crmgo.PageOptions{Sort: []string{"age"}, Cursor: opts.Cursor}, &page)
+	test.Equal(crmgo.ErrInvalidCursor, err)
+	_, err = crmgo.Paginate(ctx, family, nil, crmgo.PageOptions{Cursor: "nonsense"}, &page)
+	test.Equal(crmgo.ErrInvalidCursor, err)
+
+	// values of cursors must not inject operators
+	for _, val := range []interface{}{bson.M{"$ne": nil}, []interface{}{bson.M{"a": bson.M{"$gt": ""}}}} {
+		data, err := bson.Marshal(bson.M{"s": "age,_id", "v": []interface{}{val, "m00"}})
+		test.Nil(err)
+		_, err = crmgo.Paginate(ctx, family, nil, crmgo.PageOptions{Sort: []string{"age"}, Cursor: base64.RawURLEncoding.EncodeToString(data)}, &page)
+		test.Equal(crmgo.ErrInvalidCursor, err)
+	}
+
+	_, err = crmgo.Paginate(ctx, family, nil, crmgo.PageOptions{Sort: []string{"nickname"}, Limit: 2}, &page)
+	test.EqualError(err, "paginate: sort field nickname missing in document m01")
+
+	repo := crmgo.NewRepository[member](db, "family")
+	list, next, err := repo.Page(ctx, crmgo.Q{}.GT("Age", 0), crmgo.PageOptions{Sort: []string{"Age"}, Limit: 5})
+	test.Nil(err)
+	test.Len(list, 5)
+	list, next, err = repo.Page(ctx, crmgo.Q{}.GT("Age", 0), crmgo.PageOptions{Sort: []string{"Age"}, Limit: 5, Cursor: next})
+	test.Nil(err)
+	test.Len(list, 1)
+	test.Equal("", next)
+	test.Equal("m08", list[0].ID)
+}
@@ -1,33 +1,178 @@
 package crmgo
 
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
 // LONG LIVE CONVENIENCE!
 
+// Q is a query. Its methods add conditions and are chainable,
+// conditions on the same field are merged like {"a": {"$gt": 1, "$lt": 5}}.
 type Q map[string]interface{}
 
+var (
+	// logicalOperators may be used on the top level of a query
+	logicalOperators = map[string]bool{
+		"$and": true, "$or": true, "$nor": true, "$expr": true, "$text": true, "$where": true, "$comment": true,
+	}
+	// fieldOperators may be used on fields
+	fieldOperators = map[string]bool{
+		"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+		"$in": true, "$nin": true, "$all": true, "$exists": true, "$type": true, "$size": true, "$mod": true,
+		"$regex": true, "$options": true, "$elemMatch": true, "$not": true,
+	}
+)
+
+// op adds the operator to the conditions of key
+func (q Q) op(key, op string, val interface{}) Q {
+	cur, exists := q[key]
+	ops, ok := asQ(cur)
+	if !ok || !isOperators(ops) {
+		ops = Q{}
+		if exists {
+			ops["$eq"] = cur // keep a previous equality condition
+		}
+		q[key] = ops
+	}
+	ops[op] = val
+	return q
+}
+
+// logical appends the queries to the list of a logical operator.
+// An existing list of another type like []bson.M is converted, if possible.
+func (q Q) logical(op string, qs []Q) Q {
+	cur, exists := q[op]
+	if list, ok := cur.([]Q); ok || !exists {
+		q[op] = append(list, qs...)
+		return q
+	}
+
+	rv := reflect.ValueOf(cur)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		rv = reflect.ValueOf([]interface{}{cur})
+	}
+	list := make([]Q, 0, rv.Len()+len(qs))
+	for i := 0; i < rv.Len(); i++ {
+		elem, ok := asQ(rv.Index(i).Interface())
+		if !ok {
+			// keep what can't be converted, e.g. bson.D
+			mixed := make([]interface{}, 0, rv.Len()+len(qs))
+			for i := 0; i < rv.Len(); i++ {
+				mixed = append(mixed, rv.Index(i).Interface())
+			}
+			for _, q := range qs {
+				mixed = append(mixed, q)
+			}
+			q[op] = mixed
+			return q
+		}
+		list = append(list, elem)
+	}
+	q[op] = append(list, qs...)
+	return q
+}
+
+// Eq is equal
+func (q Q) Eq(key string, val interface{}) Q {
+	if ops, ok := asQ(q[key]); ok && isOperators(ops) {
+		return q.op(key, "$eq", val)
+	}
+	q[key] = val
+	return q
+}
+
+// NE is not equal
+func (q Q) NE(key string, val interface{}) Q {
+	return q.op(key, "$ne", val)
+}
+
 // GT is greater than
 func (q Q) GT(key string, val interface{}) Q {
-	q[key] = GT(val)
-	return q
+	return q.op(key, "$gt", val)
 }
 
 // GTE is greater than or equal
 func (q Q) GTE(key string, val interface{}) Q {
-	q[key] = GTE(val)
-	return q
+	return q.op(key, "$gte", val)
 }
 
 // LT is less than
 func (q Q) LT(key string, val interface{}) Q {
-	q[key] = LT(val)
-	return q
+	return q.op(key, "$lt", val)
 }
 
 // LTE is less than or equal
 func (q Q) LTE(key string, val interface{}) Q {
-	q[key] = LTE(val)
+	return q.op(key, "$lte", val)
+}
+
+// In is equal to one of vals
+func (q Q) In(key string, vals ...interface{}) Q {
+	return q.op(key, "$in", values(vals))
+}
+
+// NIn is equal to none of vals
+func (q Q) NIn(key string, vals ...interface{}) Q {
+	return q.op(key, "$nin", values(vals))
+}
+
+// Exists checks whether the field exists or not
+func (q Q) Exists(key string, exists bool) Q {
+	return q.op(key, "$exists", exists)
+}
+
+// Regex matches the pattern with options like "i" for case insensitivity
+func (q Q) Regex(key, pattern, options string) Q {
+	q.op(key, "$regex", pattern)
+	if options != "" {
+		q.op(key, "$options", options)
+	}
 	return q
 }
 
+// ElemMatch matches arrays having an element matching cond
+func (q Q) ElemMatch(key string, cond Q) Q {
+	return q.op(key, "$elemMatch", cond)
+}
+
+// And matches if all of qs match
+func (q Q) And(qs ...Q) Q {
+	return q.logical("$and", qs)
+}
+
+// Or matches if any of qs matches
+func (q Q) Or(qs ...Q) Q {
+	return q.logical("$or", qs)
+}
+
+// Nor matches if none of qs matches
+func (q Q) Nor(qs ...Q) Q {
+	return q.logical("$nor", qs)
+}
+
+// Validate checks the query for unknown operators and operators with invalid values
+func (q Q) Validate() error {
+	return validateQuery(q)
+}
+
+// String returns the query as JSON, e.g. for logging
+func (q Q) String() string {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Sprint(map[string]interface{}(q))
+	}
+	return string(data)
+}
+
+// NE is not equal
+func NE(val interface{}) Q {
+	return Q{"$ne": val}
+}
+
 // GT is greater than
 func GT(val interface{}) Q {
 	return Q{"$gt": val}
@@ -47,3 +192,191 @@ func LT(val interface{}) Q {
 func LTE(val interface{}) Q {
 	return Q{"$lte": val}
 }
+
+// In is equal to one of vals
+func In(vals ...interface{}) Q {
+	return Q{"$in": values(vals)}
+}
+
+// NIn is equal to none of vals
+func NIn(vals ...interface{}) Q {
+	return Q{"$nin": values(vals)}
+}
+
+// Exists checks whether the field exists or not
+func Exists(exists bool) Q {
+	return Q{"$exists": exists}
+}
+
+// Regex matches the pattern with options like "i" for case insensitivity
+func Regex(pattern, options string) Q {
+	q := Q{"$regex": pattern}
+	if options != "" {
+		q["$options"] = options
+	}
+	return q
+}
+
+// ElemMatch matches arrays having an element matching cond
+func ElemMatch(cond Q) Q {
+	return Q{"$elemMatch": cond}
+}
+
+// And matches if all of qs match
+func And(qs ...Q) Q {
+	return Q{}.And(qs...)
+}
+
+// Or matches if any of qs matches
+func Or(qs ...Q) Q {
+	return Q{}.Or(qs...)
+}
+
+// Nor matches if none of qs matches
+func Nor(qs ...Q) Q {
+	return Q{}.Nor(qs...)
+}
+
+// values expands a single slice argument, so In("a", []string{...}) works like In("a", "x", "y")
+func values(vals []interface{}) []interface{} {
+	if len(vals) != 1 {
+		return vals
+	}
+	rv := reflect.ValueOf(vals[0])
+	if !rv.IsValid() || rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return vals
+	}
+	expanded := make([]interface{}, rv.Len())
+	for i := range expanded {
+		expanded[i] = rv.Index(i).Interface()
+	}
+	return expanded
+}
+
+// asQ returns v as Q, if it is any map[string]interface{} like Q or bson.M
+func asQ(v interface{}) (Q, bool) {
+	if q, ok := v.(Q); ok {
+		return q, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.IsValid() && rv.Type().ConvertibleTo(reflect.TypeOf(Q{})) {
+		return rv.Convert(reflect.TypeOf(Q{})).Interface().(Q), true
+	}
+	return nil, false
+}
+
+// isOperators tells whether all keys of q are operators
+func isOperators(q Q) bool {
+	for key := range q {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return len(q) > 0
+}
+
+// sortedKeys makes validation errors reproducible
+func sortedKeys(q Q) []string {
+	keys := make([]string, 0, len(q))
+	for key := range q {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validateQuery(q Q) error {
+	for _, key := range sortedKeys(q) {
+		val := q[key]
+		if strings.HasPrefix(key, "$") {
+			if !logicalOperators[key] {
+				return fmt.Errorf("unknown operator %s", key)
+			}
+			if key == "$and" || key == "$or" || key == "$nor" {
+				if err := validateList(key, val); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := validateField(key, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateList(op string, val interface{}) error {
+	rv := reflect.ValueOf(val)
+	if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() == 0 {
+		return fmt.Errorf("%s needs a non empty array of queries", op)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		sub, ok := asQ(rv.Index(i).Interface())
+		if !ok {
+			return fmt.Errorf("%s needs a non empty array of queries", op)
+		}
+		if err := validateQuery(sub); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+func validateField(key string, val interface{}) error {
+	ops, ok := asQ(val)
+	if !ok {
+		return nil // equality
+	}
+	if !isOperators(ops) {
+		for op := range ops {
+			if strings.HasPrefix(op, "$") {
+				return fmt.Errorf("%s: mixes operators and fields", key)
+			}
+		}
+		return nil // equality to a document
+	}
+
+	for _, op := range sortedKeys(ops) {
+		arg := ops[op]
+		if !fieldOperators[op] {
+			return fmt.Errorf("%s: unknown operator %s", key, op)
+		}
+		var err error
+		switch op {
+		case "$in", "$nin", "$all":
+			if kind := reflect.ValueOf(arg).Kind(); kind != reflect.Slice && kind != reflect.Array {
+				err = fmt.Errorf("%s: %s needs an array", key, op)
+			}
+		case "$exists":
+			if _, ok := arg.(bool); !ok {
+				err = fmt.Errorf("%s: %s needs a bool", key, op)
+			}
+		case "$regex", "$options":
+			if _, ok := arg.(string); !ok && op == "$options" {
+				err = fmt.Errorf("%s: %s needs a string", key, op)
+			}
+			if _, ok := ops["$regex"]; !ok {
+				err = fmt.Errorf("%s: $options needs $regex", key)
+			}
+		case "$elemMatch":
+			sub, ok := asQ(arg)
+			switch {
+			case !ok:
+				err = fmt.Errorf("%s: %s needs a query", key, op)
+			case isOperators(sub):
+				err = validateField(key, sub)
+			default:
+				err = validateQuery(sub)
+			}
+		case "$not":
+			if sub, ok := asQ(arg); ok {
+				err = validateField(key, sub)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
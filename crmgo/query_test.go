@@ -0,0 +1,76 @@
+package crmgo_test
+
+import (
+	"context"
+	"testing"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestQuery(t *testing.T) {
+	test := assert.New(t)
+
+	q := crmgo.Q{}.GT("age", 1).LT("age", 5).Eq("name", "Tim")
+	test.Equal(crmgo.Q{"age": crmgo.Q{"$gt": 1, "$lt": 5}, "name": "Tim"}, q)
+
+	q = crmgo.Q{"name": "Tim"}.NE("name", "Tom")
+	test.Equal(crmgo.Q{"name": crmgo.Q{"$eq": "Tim", "$ne": "Tom"}}, q)
+
+	q = crmgo.Q{}.In("name", []string{"Tim", "Tom"}).NIn("age", 1, 2)
+	test.Equal(crmgo.Q{
+		"name": crmgo.Q{"$in": []interface{}{"Tim", "Tom"}},
+		"age":  crmgo.Q{"$nin": []interface{}{1, 2}},
+	}, q)
+
+	q = crmgo.Q{}.Or(crmgo.Q{"a": 1}).Or(crmgo.Q{"b": 2})
+	test.Equal(crmgo.Q{"$or": []crmgo.Q{{"a": 1}, {"b": 2}}}, q)
+	test.Equal(crmgo.Or(crmgo.Q{"a": 1}, crmgo.Q{"b": 2}), q)
+
+	q = crmgo.Q{"$or": []bson.M{{"a": 1}}}.Or(crmgo.Q{"b": 2})
+	test.Equal(crmgo.Q{"$or": []crmgo.Q{{"a": 1}, {"b": 2}}}, q)
+	q = crmgo.Q{"$and": []interface{}{bson.M{"a": 1}, bson.D{{Name: "c", Value: 3}}}}.And(crmgo.Q{"b": 2})
+	test.Equal(crmgo.Q{"$and": []interface{}{bson.M{"a": 1}, bson.D{{Name: "c", Value: 3}}, crmgo.Q{"b": 2}}}, q)
+
+	q = crmgo.Q{}.Regex("name", "^ti", "i").Exists("age", true)
+	test.Equal(`{"age":{"$exists":true},"name":{"$options":"i","$regex":"^ti"}}`, q.String())
+
+	test.Nil(crmgo.Q{}.GT("a", 1).ElemMatch("b", crmgo.Q{"c": crmgo.GT(1)}).Nor(crmgo.Q{"d": 1}).Validate())
+	test.Nil(crmgo.Q{"a": crmgo.Q{"b": 1}}.Validate())
+	test.Nil(crmgo.Q{"a": crmgo.ElemMatch(crmgo.GT(1))}.Validate())
+
+	test.EqualError(crmgo.Q{"a": crmgo.Q{"$gtt": 1}}.Validate(), "a: unknown operator $gtt")
+	test.EqualError(crmgo.Q{"$xor": 1}.Validate(), "unknown operator $xor")
+	test.EqualError(crmgo.Q{"a": crmgo.Q{"$in": 1}}.Validate(), "a: $in needs an array")
+	test.EqualError(crmgo.Q{"a": crmgo.Q{"$exists": 1}}.Validate(), "a: $exists needs a bool")
+	test.EqualError(crmgo.Q{"a": crmgo.Q{"$options": "i"}}.Validate(), "a: $options needs $regex")
+	test.EqualError(crmgo.Q{"a": crmgo.Q{"$gt": 1, "b": 2}}.Validate(), "a: mixes operators and fields")
+	test.EqualError(crmgo.Q{"$or": []crmgo.Q{}}.Validate(), "$or needs a non empty array of queries")
+	test.EqualError(crmgo.And(crmgo.Q{"a": crmgo.Q{"$ne": 1, "$eqq": 2}}).Validate(), "$and: a: unknown operator $eqq")
+}
+
+func TestQueryMemory(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	family := openMemory(t).Collection("family")
+	insertFamily(t, family)
+
+	count := func(q crmgo.Q) int {
+		n, err := family.Count(ctx, q)
+		test.Nil(err)
+		return n
+	}
+
+	test.Equal(3, count(crmgo.Q{}.NE("name", "Tim")))
+	test.Equal(2, count(crmgo.Q{}.NIn("name", "Tim", "Mary")))
+	test.Equal(2, count(crmgo.Q{}.Exists("tags", true)))
+	test.Equal(2, count(crmgo.Q{}.Exists("tags", false)))
+	test.Equal(2, count(crmgo.Q{}.Regex("name", "^[gt]", "i")))
+	test.Equal(1, count(crmgo.Q{"name": crmgo.Q{"$not": crmgo.Regex("^[gtl]", "i")}}))
+	test.Equal(2, count(crmgo.Q{}.ElemMatch("tags", crmgo.Q{"$eq": "parent"})))
+	test.Equal(1, count(crmgo.Q{}.Nor(crmgo.Q{}.LT("age", 10), crmgo.Q{}.GT("age", 20))))
+	test.Equal(1, count(crmgo.And(crmgo.Q{}.GT("age", 10), crmgo.Q{}.LT("age", 20))))
+	test.Equal(1, count(crmgo.Q{}.GT("age", 1).LT("age", 10).NE("name", "Lisa")))
+}
@@ -0,0 +1,307 @@
+package crmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cleverreach.com/crtools/meta"
+)
+
+// ErrUnknownField is returned if a query or update references a field not in the struct of a Repository
+var ErrUnknownField = errors.New("unknown field")
+
+// Repository gives typed access to a collection of documents of the struct type T.
+// Fields in queries, updates and sorts may be given by their bson name or Go name,
+// like "email" or "Email", nested ones like "address.city" or "Address.City".
+// They are checked against T and translated to the bson names.
+type Repository[T any] struct {
+	coll Collection
+	root *fieldNode
+}
+
+// fieldNode is a field of a document with its sub fields
+type fieldNode struct {
+	name     string                // bson name
+	open     bool                  // sub fields can't be checked, like of maps
+	children map[string]*fieldNode // by Go and bson name
+}
+
+// NewRepository returns a Repository for the collection of db with documents of type T
+func NewRepository[T any](db *DB, collection string) *Repository[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		panic("crmgo: Repository needs a struct type, not " + t.String())
+	}
+	root := &fieldNode{children: map[string]*fieldNode{}}
+	addFields(root, t)
+	if root.children["_id"] == nil {
+		root.children["_id"] = &fieldNode{name: "_id"}
+	}
+	return &Repository[T]{coll: db.Collection(collection), root: root}
+}
+
+// addFields adds the fields of struct t to node, like mgo marshals them
+func addFields(node *fieldNode, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("bson")
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if strings.Contains(tag, ",inline") {
+			if ft.Kind() == reflect.Struct {
+				addFields(node, ft)
+			} else {
+				node.open = true // inlined map
+			}
+			continue
+		}
+
+		child := &fieldNode{name: bsonName(field)}
+		name := child.name
+		node.children[field.Name] = child
+		node.children[name] = child
+
+		for ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array || ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}):
+			child.children = map[string]*fieldNode{}
+			addFields(child, ft)
+		case ft.Kind() == reflect.Map || ft.Kind() == reflect.Interface:
+			child.open = true
+		}
+	}
+}
+
+// bsonName returns the name of a field in documents like mgo marshals it
+func bsonName(field reflect.StructField) string {
+	name, _ := meta.GetBSONName(field)
+	if field.Tag.Get("bson") == "" || name == "" {
+		name = strings.ToLower(field.Name) // mgo's default
+	}
+	return name
+}
+
+// Collection returns the underlying Collection
+func (r *Repository[T]) Collection() Collection {
+	return r.coll
+}
+
+// Field returns the bson name of a field given by Go or bson name, or panics, if there is none.
+// Use it to reference fields in queries built by hand.
+func (r *Repository[T]) Field(name string) string {
+	field, err := r.field(name)
+	if err != nil {
+		panic(err)
+	}
+	return field
+}
+
+// FindByID finds the document with the _id, or returns ErrNotFound
+func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	return r.FindOne(ctx, Q{"_id": id})
+}
+
+// FindOne finds the first document matching q, or returns ErrNotFound
+func (r *Repository[T]) FindOne(ctx context.Context, q Q) (*T, error) {
+	filter, err := r.query(q)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(T)
+	if err = r.coll.FindOne(ctx, filter, nil, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// FindMany finds all documents matching q
+func (r *Repository[T]) FindMany(ctx context.Context, q Q, opts *FindOptions) ([]T, error) {
+	filter, err := r.query(q)
+	if err != nil {
+		return nil, err
+	}
+	if opts, err = r.findOptions(opts); err != nil {
+		return nil, err
+	}
+	var docs []T
+	if err = r.coll.Find(ctx, filter, opts, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// Insert inserts the documents
+func (r *Repository[T]) Insert(ctx context.Context, docs ...T) error {
+	list := make([]interface{}, len(docs))
+	for i := range docs {
+		list[i] = &docs[i]
+	}
+	return r.coll.Insert(ctx, list...)
+}
+
+// Update applies u to the first or, with opts.Multi, all documents matching q
+func (r *Repository[T]) Update(ctx context.Context, q Q, u U, opts *UpdateOptions) (*UpdateResult, error) {
+	filter, err := r.query(q)
+	if err != nil {
+		return nil, err
+	}
+	update, err := r.update(u)
+	if err != nil {
+		return nil, err
+	}
+	return r.coll.Update(ctx, filter, update, opts)
+}
+
+// Upsert applies u to the first document matching q or inserts one
+func (r *Repository[T]) Upsert(ctx context.Context, q Q, u U) (*UpdateResult, error) {
+	return r.Update(ctx, q, u, &UpdateOptions{Upsert: true})
+}
+
+// Delete deletes the first or, if multi, all documents matching q
+func (r *Repository[T]) Delete(ctx context.Context, q Q, multi bool) (int, error) {
+	filter, err := r.query(q)
+	if err != nil {
+		return 0, err
+	}
+	return r.coll.Delete(ctx, filter, multi)
+}
+
+// Count counts the documents matching q
+func (r *Repository[T]) Count(ctx context.Context, q Q) (int, error) {
+	filter, err := r.query(q)
+	if err != nil {
+		return 0, err
+	}
+	return r.coll.Count(ctx, filter)
+}
+
+// field resolves a field path to its bson path
+func (r *Repository[T]) field(path string) (string, error) {
+	node := r.root
+	parts := strings.Split(path, ".")
+	out := make([]string, 0, len(parts))
+	for i, part := range parts {
+		if isPosition(part) {
+			out = append(out, part)
+			continue
+		}
+		if node.open {
+			return strings.Join(append(out, parts[i:]...), "."), nil
+		}
+		child, ok := node.children[part]
+		if !ok {
+			return "", fmt.Errorf("%w %s in %T", ErrUnknownField, path, *new(T))
+		}
+		out = append(out, child.name)
+		node = child
+	}
+	return strings.Join(out, "."), nil
+}
+
+// isPosition tells whether part of a path is an array index or positional operator like "$[]"
+func isPosition(part string) bool {
+	if _, err := strconv.Atoi(part); err == nil {
+		return true
+	}
+	return strings.HasPrefix(part, "$")
+}
+
+// query translates the fields of q and validates it
+func (r *Repository[T]) query(q Q) (Q, error) {
+	res := Q{}
+	for key, val := range q {
+		switch key {
+		case "$and", "$or", "$nor":
+			list, err := r.queries(key, val)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = list
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			res[key] = val
+			continue
+		}
+		field, err := r.field(key)
+		if err != nil {
+			return nil, err
+		}
+		res[field] = val
+	}
+	return res, res.Validate()
+}
+
+func (r *Repository[T]) queries(op string, val interface{}) ([]Q, error) {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%s needs a non empty array of queries", op)
+	}
+	list := make([]Q, rv.Len())
+	for i := range list {
+		sub, ok := asQ(rv.Index(i).Interface())
+		if !ok {
+			return nil, fmt.Errorf("%s needs a non empty array of queries", op)
+		}
+		var err error
+		if list[i], err = r.query(sub); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// update translates the fields of u
+func (r *Repository[T]) update(u U) (U, error) {
+	res := U{}
+	for op, val := range u {
+		fields, ok := asQ(val)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a document", op)
+		}
+		translated := Q{}
+		for key, v := range fields {
+			field, err := r.field(key)
+			if err != nil {
+				return nil, err
+			}
+			translated[field] = v
+		}
+		res[op] = translated
+	}
+	return res, nil
+}
+
+// findOptions translates the fields to sort by
+func (r *Repository[T]) findOptions(opts *FindOptions) (*FindOptions, error) {
+	if opts == nil || len(opts.Sort) == 0 {
+		return opts, nil
+	}
+	res := *opts
+	res.Sort = make([]string, len(opts.Sort))
+	for i, key := range opts.Sort {
+		desc := strings.HasPrefix(key, "-")
+		field, err := r.field(strings.TrimLeft(key, "+-"))
+		if err != nil {
+			return nil, err
+		}
+		if desc {
+			field = "-" + field
+		}
+		res.Sort[i] = field
+	}
+	return &res, nil
+}
@@ -0,0 +1,92 @@
+package crmgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	City string `bson:"city"`
+	Zip  string
+}
+
+type person struct {
+	ID      string            `bson:"_id"`
+	Email   string            `bson:"email"`
+	Age     int               `bson:"age,omitempty"`
+	Address address           `bson:"addr"`
+	Extra   map[string]string `bson:"extra,omitempty"`
+	Secret  string            `bson:"-"`
+}
+
+func TestRepository(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	repo := crmgo.NewRepository[person](openMemory(t), "people")
+	test.Equal("addr.city", repo.Field("Address.City"))
+	test.Equal("addr.zip", repo.Field("addr.Zip"))
+	test.Equal("extra.anything", repo.Field("Extra.anything"))
+	test.Equal("addr.$.city", repo.Field("Address.$.City"))
+	test.Panics(func() { repo.Field("Secret") })
+
+	err := repo.Insert(ctx,
+		person{ID: "1", Email: "a@example.com", Age: 20, Address: address{City: "Berlin"}},
+		person{ID: "2", Email: "b@example.com", Age: 30, Address: address{City: "Hamburg"}},
+		person{ID: "3", Email: "c@example.com", Age: 40, Address: address{City: "Berlin"}},
+	)
+	test.Nil(err)
+
+	p, err := repo.FindByID(ctx, "2")
+	test.Nil(err)
+	test.Equal("b@example.com", p.Email)
+	_, err = repo.FindByID(ctx, "4")
+	test.Equal(crmgo.ErrNotFound, err)
+
+	p, err = repo.FindOne(ctx, crmgo.Q{"Email": "c@example.com"})
+	test.Nil(err)
+	test.Equal("3", p.ID)
+
+	found, err := repo.FindMany(ctx, crmgo.Q{"Address.City": "Berlin"}, &crmgo.FindOptions{Sort: []string{"-Age"}})
+	test.Nil(err)
+	if test.Len(found, 2) {
+		test.Equal("3", found[0].ID)
+		test.Equal("1", found[1].ID)
+	}
+
+	found, err = repo.FindMany(ctx, crmgo.Or(crmgo.Q{}.GT("Age", 35), crmgo.Q{"email": "a@example.com"}), nil)
+	test.Nil(err)
+	test.Len(found, 2)
+
+	res, err := repo.Update(ctx, crmgo.Q{}.LT("Age", 35), crmgo.U{}.Inc("Age", 1).Set("Address.Zip", "10115"), &crmgo.UpdateOptions{Multi: true})
+	test.Nil(err)
+	test.Equal(2, res.Updated)
+	p, _ = repo.FindByID(ctx, "1")
+	test.Equal(21, p.Age)
+	test.Equal("10115", p.Address.Zip)
+
+	res, err = repo.Upsert(ctx, crmgo.Q{"ID": "4"}, crmgo.U{}.Set("Email", "d@example.com"))
+	test.Nil(err)
+	test.Equal("4", res.UpsertedID)
+
+	n, err := repo.Count(ctx, nil)
+	test.Nil(err)
+	test.Equal(4, n)
+
+	n, err = repo.Delete(ctx, crmgo.Q{"Address.City": "Berlin"}, true)
+	test.Nil(err)
+	test.Equal(2, n)
+
+	_, err = repo.FindOne(ctx, crmgo.Q{"Mail": "a@example.com"})
+	test.True(errors.Is(err, crmgo.ErrUnknownField))
+	_, err = repo.FindMany(ctx, nil, &crmgo.FindOptions{Sort: []string{"Secret"}})
+	test.True(errors.Is(err, crmgo.ErrUnknownField))
+	_, err = repo.Update(ctx, crmgo.Q{"ID": "1"}, crmgo.U{}.Set("Address.Street", "x"), nil)
+	test.EqualError(err, "unknown field Address.Street in crmgo_test.person")
+	_, err = repo.Count(ctx, crmgo.Q{"Age": crmgo.Q{"$gtt": 1}})
+	test.EqualError(err, "age: unknown operator $gtt")
+}
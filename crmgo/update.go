@@ -0,0 +1,158 @@
+package crmgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// U is an update document. Its methods add operations and are chainable,
+// so U{}.Set("name", "Tim").Inc("visits", 1) is {"$set": {"name": "Tim"}, "$inc": {"visits": 1}}.
+type U map[string]interface{}
+
+// field adds the field of an update operator
+func (u U) field(op, key string, val interface{}) U {
+	fields, ok := u[op].(Q)
+	if !ok {
+		fields = Q{}
+		u[op] = fields
+	}
+	fields[key] = val
+	return u
+}
+
+// Set sets the field to val
+func (u U) Set(key string, val interface{}) U {
+	return u.field("$set", key, val)
+}
+
+// SetOnInsert sets the field to val, if an upsert inserts the document
+func (u U) SetOnInsert(key string, val interface{}) U {
+	return u.field("$setOnInsert", key, val)
+}
+
+// Unset removes the fields
+func (u U) Unset(keys ...string) U {
+	for _, key := range keys {
+		u.field("$unset", key, "")
+	}
+	return u
+}
+
+// Inc increments the field by val, which may be negative
+func (u U) Inc(key string, val interface{}) U {
+	return u.field("$inc", key, val)
+}
+
+// Push appends the values to the array. A single slice appends its values, like In does.
+// Repeated calls for the same key append the values of all of them.
+func (u U) Push(key string, vals ...interface{}) U {
+	return u.appendEach("$push", key, vals)
+}
+
+// AddToSet appends the values to the array, unless they are in it already, see Push
+func (u U) AddToSet(key string, vals ...interface{}) U {
+	return u.appendEach("$addToSet", key, vals)
+}
+
+// Pull removes all values equal to cond or, if cond is a Q, matching it. A slice removes each of its values.
+// Repeated calls for the same key remove what matches any of them:
+// values and In conditions are merged into one $in, queries of array documents into $or.
+// Other conditions like GT(5) can't be merged and replace earlier ones.
+func (u U) Pull(key string, cond interface{}) U {
+	if vals, ok := pullValues(cond); ok && len(vals) != 1 {
+		cond = Q{"$in": vals}
+	}
+	fields, _ := u["$pull"].(Q)
+	if prev, exists := fields[key]; exists {
+		cond = mergePull(prev, cond)
+	}
+	return u.field("$pull", key, cond)
+}
+
+// CurrentDate sets the field to the current date
+func (u U) CurrentDate(key string) U {
+	return u.field("$currentDate", key, true)
+}
+
+// Min sets the field to val, if val is less than its value
+func (u U) Min(key string, val interface{}) U {
+	return u.field("$min", key, val)
+}
+
+// Max sets the field to val, if val is greater than its value
+func (u U) Max(key string, val interface{}) U {
+	return u.field("$max", key, val)
+}
+
+// String returns the update as JSON, e.g. for logging
+func (u U) String() string {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Sprint(map[string]interface{}(u))
+	}
+	return string(data)
+}
+
+// appendEach adds the values to the ones of a previous call for the field of op
+func (u U) appendEach(op, key string, vals []interface{}) U {
+	vals = values(vals)
+	fields, _ := u[op].(Q)
+	if prev, exists := fields[key]; exists {
+		list := []interface{}{prev}
+		if mods, ok := asQ(prev); ok && isOperators(mods) {
+			list, _ = mods["$each"].([]interface{})
+		}
+		vals = append(list[:len(list):len(list)], vals...)
+	}
+	return u.field(op, key, each(vals))
+}
+
+// each returns a single value as it is and multiple ones as {"$each": vals}
+func each(vals []interface{}) interface{} {
+	if len(vals) == 1 {
+		return vals[0]
+	}
+	return Q{"$each": vals}
+}
+
+// pullValues returns the values removed by a $pull condition, which is a value, a slice or an $in
+func pullValues(cond interface{}) ([]interface{}, bool) {
+	q, ok := asQ(cond)
+	if !ok {
+		return values([]interface{}{cond}), true
+	}
+	if in, ok := q["$in"]; ok && len(q) == 1 {
+		return values([]interface{}{in}), true
+	}
+	return nil, false
+}
+
+// mergePull combines two $pull conditions of a field, so values matching any of them are removed
+func mergePull(prev, cond interface{}) interface{} {
+	if a, ok := pullValues(prev); ok {
+		if b, ok := pullValues(cond); ok {
+			return Q{"$in": append(a[:len(a):len(a)], b...)}
+		}
+	}
+	a, okA := asQ(prev)
+	b, okB := asQ(cond)
+	if !okA || !okB || (isOperators(a) && a["$or"] == nil) || isOperators(b) {
+		return cond
+	}
+	if list, ok := a["$or"].([]Q); ok && len(a) == 1 {
+		return Q{"$or": append(list[:len(list):len(list)], b)}
+	}
+	return Q{"$or": []Q{a, b}}
+}
+
+// Upsert updates the first document matching filter in the collection or inserts one,
+// made of the equality conditions of filter and the update.
+func (d *DB) Upsert(ctx context.Context, collection string, filter Q, update U) (*UpdateResult, error) {
+	return d.Collection(collection).Update(ctx, filter, update, &UpdateOptions{Upsert: true})
+}
+
+// UpsertID updates or inserts the document with the _id in the collection
+func (d *DB) UpsertID(ctx context.Context, collection string, id interface{}, update U) (*UpdateResult, error) {
+	return d.Upsert(ctx, collection, Q{"_id": id}, update)
+}
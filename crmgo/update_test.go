@@ -0,0 +1,106 @@
+package crmgo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cleverreach.com/crtools/crmgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	test := assert.New(t)
+
+	u := crmgo.U{}.Set("name", "Tim").Set("age", 6).Inc("visits", 1).Push("tags", "a", "b").Unset("old")
+	test.Equal(crmgo.U{
+		"$set":   crmgo.Q{"name": "Tim", "age": 6},
+		"$inc":   crmgo.Q{"visits": 1},
+		"$push":  crmgo.Q{"tags": crmgo.Q{"$each": []interface{}{"a", "b"}}},
+		"$unset": crmgo.Q{"old": ""},
+	}, u)
+	test.Equal(`{"$addToSet":{"tags":"a"},"$currentDate":{"seen":true}}`, crmgo.U{}.AddToSet("tags", "a").CurrentDate("seen").String())
+}
+
+func TestUpdateRepeated(t *testing.T) {
+	test := assert.New(t)
+
+	u := crmgo.U{}.Push("tags", "a").Push("tags", "b", "c").AddToSet("ids", []int{1, 2}).AddToSet("ids", 3)
+	test.Equal(crmgo.U{
+		"$push":     crmgo.Q{"tags": crmgo.Q{"$each": []interface{}{"a", "b", "c"}}},
+		"$addToSet": crmgo.Q{"ids": crmgo.Q{"$each": []interface{}{1, 2, 3}}},
+	}, u)
+	test.Equal(crmgo.U{"$push": crmgo.Q{"tags": "a"}}, crmgo.U{}.Push("tags", []string{"a"}))
+
+	u = crmgo.U{}.Pull("tags", "a").Pull("tags", crmgo.In("b", "c")).Pull("ids", []int{1, 2})
+	test.Equal(crmgo.U{"$pull": crmgo.Q{
+		"tags": crmgo.Q{"$in": []interface{}{"a", "b", "c"}},
+		"ids":  crmgo.Q{"$in": []interface{}{1, 2}},
+	}}, u)
+
+	u = crmgo.U{}.Pull("items", crmgo.Q{"a": 1}).Pull("items", crmgo.Q{"b": 2}).Pull("items", crmgo.Q{"c": 3})
+	test.Equal(crmgo.U{"$pull": crmgo.Q{"items": crmgo.Q{"$or": []crmgo.Q{{"a": 1}, {"b": 2}, {"c": 3}}}}}, u)
+
+	u = crmgo.U{}.Pull("n", crmgo.GT(5)).Pull("n", crmgo.LT(1))
+	test.Equal(crmgo.U{"$pull": crmgo.Q{"n": crmgo.LT(1)}}, u)
+}
+
+func TestUpdateMemory(t *testing.T) {
+	test := assert.New(t)
+	ctx := context.Background()
+
+	db := openMemory(t)
+	family := db.Collection("family")
+	insertFamily(t, family)
+
+	type stats struct {
+		ID     string    `bson:"_id"`
+		Name   string    `bson:"name"`
+		Visits int       `bson:"visits"`
+		Low    int       `bson:"low"`
+		High   int       `bson:"high"`
+		Tags   []string  `bson:"tags"`
+		Seen   time.Time `bson:"seen"`
+	}
+
+	upd := crmgo.U{}.SetOnInsert("name", "Bob").Inc("visits", 1).Min("low", 5).Max("high", 5).AddToSet("tags", "a", "b").CurrentDate("seen")
+	res, err := db.UpsertID(ctx, "family", "bob", upd)
+	test.Nil(err)
+	test.Equal("bob", res.UpsertedID)
+
+	upd = crmgo.U{}.SetOnInsert("name", "Robert").Inc("visits", 1).Min("low", 3).Max("high", 3).AddToSet("tags", "b", "c")
+	res, err = db.UpsertID(ctx, "family", "bob", upd)
+	test.Nil(err)
+	test.Equal(1, res.Updated)
+
+	var bob stats
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "bob"}, nil, &bob))
+	test.Equal("Bob", bob.Name)
+	test.Equal(2, bob.Visits)
+	test.Equal(3, bob.Low)
+	test.Equal(5, bob.High)
+	test.Equal([]string{"a", "b", "c"}, bob.Tags)
+	test.WithinDuration(time.Now(), bob.Seen, time.Minute)
+
+	_, err = db.Upsert(ctx, "family", crmgo.Q{"_id": "bob"}, crmgo.U{}.Pull("tags", crmgo.In("a", "c")).Unset("high", "seen"))
+	test.Nil(err)
+	var doc crmgo.Q
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "bob"}, nil, &doc))
+	test.Equal([]interface{}{"b"}, doc["tags"])
+	test.NotContains(doc, "high")
+	test.NotContains(doc, "seen")
+
+	_, err = family.Update(ctx, crmgo.Q{"_id": "mary"}, crmgo.U{}.Pull("tags", "parent"), nil)
+	test.Nil(err)
+	n, _ := family.Count(ctx, crmgo.Q{"tags": "parent"})
+	test.Equal(1, n)
+
+	_, err = family.Update(ctx, crmgo.Q{"_id": "tim"}, crmgo.U{}.Push("tags", "a").Push("tags", []string{"b", "c"}).Push("toys", crmgo.Q{"name": "car"}, crmgo.Q{"name": "ball"}, crmgo.Q{"name": "doll"}), nil)
+	test.Nil(err)
+	_, err = family.Update(ctx, crmgo.Q{"_id": "tim"}, crmgo.U{}.Pull("tags", "a").Pull("tags", "c").Pull("toys", crmgo.Q{"name": "car"}).Pull("toys", crmgo.Q{"name": "doll"}), nil)
+	test.Nil(err)
+	doc = nil
+	test.Nil(family.FindOne(ctx, crmgo.Q{"_id": "tim"}, nil, &doc))
+	test.Equal([]interface{}{"b"}, doc["tags"])
+	test.Equal([]interface{}{crmgo.Q{"name": "ball"}}, doc["toys"])
+}
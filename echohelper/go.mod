@@ -6,4 +6,5 @@ require (
 	cleverreach.com/crtools/crtoken v1.1.2
 	cleverreach.com/crtools/rest v1.0.1
 	github.com/labstack/echo/v4 v4.1.16
+	github.com/stretchr/testify v1.6.1
 )
@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"net/http"
+	"strconv"
+
+	"cleverreach.com/crtools/rest"
+	"github.com/labstack/echo/v4"
+)
+
+// Page holds the parameters of a request for a page of a list
+type Page struct {
+	// Cursor is the continuation token of the previous page, empty for the first one
+	Cursor string
+	// Limit is the size of the page
+	Limit int
+}
+
+var (
+	// DefaultLimit is used, if a request has no limit parameter
+	DefaultLimit = 50
+	// MaxLimit is the highest limit a request may ask for
+	MaxLimit = 500
+	// NextCursorHeader is the response header holding the cursor of the next page
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// Paginate is a middleware func reading the query parameters `cursor` and `limit` of paginated requests.
+// You get them from Context by `c.Get("page").(helper.Page)`
+func Paginate() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			page := Page{Cursor: c.QueryParam("cursor"), Limit: DefaultLimit}
+			if limit := c.QueryParam("limit"); limit != "" {
+				l, err := strconv.Atoi(limit)
+				if err != nil || l < 1 || l > MaxLimit {
+					return c.JSON(rest.Error(http.StatusBadRequest, "invalid limit"))
+				}
+				page.Limit = l
+			}
+			c.Set("page", page)
+			return next(c)
+		}
+	}
+}
+
+// SetNextCursor adds the cursor of the next page to the response headers,
+// as NextCursorHeader and as Link header with the URL of the next page.
+// An empty cursor means there is no next page and sets nothing.
+func SetNextCursor(c echo.Context, cursor string) {
+	if cursor == "" {
+		return
+	}
+	link := *c.Request().URL
+	query := link.Query()
+	query.Set("cursor", cursor)
+	link.RawQuery = query.Encode()
+
+	header := c.Response().Header()
+	header.Set(NextCursorHeader, cursor)
+	header.Set("Link", "<"+link.String()+`>; rel="next"`)
+}
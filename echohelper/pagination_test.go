@@ -0,0 +1,47 @@
+package helper_test
+
+import (
+	"net/http"
+	"testing"
+
+	helper "cleverreach.com/crtools/echohelper"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	test := assert.New(t)
+
+	r := helper.NewTestRouter()
+	r.Echo.GET("/dishes", func(c echo.Context) error {
+		page := c.Get("page").(helper.Page)
+		next := ""
+		if page.Cursor == "" {
+			next = "abc"
+		}
+		helper.SetNextCursor(c, next)
+		return c.JSON(http.StatusOK, page)
+	}, helper.Paginate())
+
+	var page helper.Page
+	r.Request(http.MethodGet, "/dishes?sort=name", nil)
+	res := r.Start()
+	test.Equal(http.StatusOK, res.Code)
+	test.Nil(res.Bind(&page))
+	test.Equal(helper.Page{Limit: helper.DefaultLimit}, page)
+	test.Equal("abc", res.Header.Get(helper.NextCursorHeader))
+	test.Equal(`</dishes?cursor=abc&sort=name>; rel="next"`, res.Header.Get("Link"))
+
+	r.Request(http.MethodGet, "/dishes?cursor=abc&limit=10", nil)
+	res = r.Start()
+	test.Equal(http.StatusOK, res.Code)
+	test.Nil(res.Bind(&page))
+	test.Equal(helper.Page{Cursor: "abc", Limit: 10}, page)
+	test.Empty(res.Header.Get(helper.NextCursorHeader))
+	test.Empty(res.Header.Get("Link"))
+
+	for _, limit := range []string{"0", "-1", "501", "ten"} {
+		r.Request(http.MethodGet, "/dishes?limit="+limit, nil)
+		test.Equal(http.StatusBadRequest, r.Start().Code, limit)
+	}
+}
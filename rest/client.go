@@ -56,9 +56,9 @@ func NewClientFromURL(urlPath string) *Client {
 		params: make(map[string]string),
 		header: make(map[string]string),
 
-		timeout:           crconfig.GetDuration("REST_CLIENT_TIMEOUT", 0) * time.Millisecond,
+		timeout:           crconfig.GetDurationIn("REST_CLIENT_TIMEOUT", time.Millisecond, 0),
 		retries:           int(crconfig.GetInt("REST_CLIENT_RETRIES", 0)),
-		maxRetryTime:      crconfig.GetDuration("REST_CLIENT_MAX_RETRY_TIME", 60) * time.Second,
+		maxRetryTime:      crconfig.GetDurationIn("REST_CLIENT_MAX_RETRY_TIME", time.Second, 60*time.Second),
 		ignoreCertificate: crconfig.GetBool("REST_CLIENT_IGNORE_CERTIFICATE", IgnoreCertificate),
 	}
 
@@ -116,9 +116,9 @@ func NewClient(urlParts ...interface{}) *Client {
 		params: make(map[string]string),
 		header: make(map[string]string),
 
-		timeout:           crconfig.GetDuration("REST_CLIENT_TIMEOUT", 0) * time.Millisecond,
+		timeout:           crconfig.GetDurationIn("REST_CLIENT_TIMEOUT", time.Millisecond, 0),
 		retries:           int(crconfig.GetInt("REST_CLIENT_RETRIES", 0)),
-		maxRetryTime:      crconfig.GetDuration("REST_CLIENT_MAX_RETRY_TIME", 60) * time.Second,
+		maxRetryTime:      crconfig.GetDurationIn("REST_CLIENT_MAX_RETRY_TIME", time.Second, 60*time.Second),
 		ignoreCertificate: crconfig.GetBool("REST_CLIENT_IGNORE_CERTIFICATE", IgnoreCertificate),
 	}
 
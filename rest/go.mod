@@ -3,7 +3,7 @@ module cleverreach.com/crtools/rest
 go 1.14
 
 require (
-	cleverreach.com/crtools/crconfig v1.0.1
+	cleverreach.com/crtools/crconfig v1.1.0
 	github.com/stretchr/testify v1.6.1
 	gopkg.in/h2non/gock.v1 v1.0.15
 )